@@ -51,21 +51,25 @@ struct S1Capn {
  } 
     
   func (s *s1) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	s1GoToCapn(seg, s)
-      _, err := seg.WriteTo(w)
-      return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *s1) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	s1GoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
    
   func (s *s1) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-          return err
-    	}
-    	z := ReadRootS1Capn(capMsg)
-        S1CapnToGo(z, s)
-     return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootS1Capn(capMsg)
+  	S1CapnToGo(z, s)
+  	return nil
   }
   
   func S1CapnToGo(src S1Capn, dest *s1) *s1 {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// regexCapannot matches a // capannot: line in a struct's doc comment,
+// e.g. // capannot: $Json.discriminator("kind"). The comment must start
+// with capannot:, so prose that mentions it isn't taken for one.
+var regexCapannot = regexp.MustCompile(`(?m)^[ \t]*//[ \t]*capannot[ \t]*:[ \t]*(.*?)[ \t]*$`)
+
+// regexCapannotDirective matches a comment line that is a capannot:
+// directive, which the schema comments leave out.
+var regexCapannotDirective = regexp.MustCompile(`^capannot[ \t]*:`)
+
+// structAnnotations returns the capnp annotations in the // capannot:
+// lines of comment, joined with spaces, e.g. $Foo(1) $Bar.
+func structAnnotations(comment string) string {
+	var annots []string
+	for _, match := range regexCapannot.FindAllStringSubmatch(comment, -1) {
+		if match[1] != "" {
+			annots = append(annots, match[1])
+		}
+	}
+	return strings.Join(annots, " ")
+}
+
+// capannotFromTag returns the value of the capannot key in the struct
+// tag literal tagLit: $Json.name("id") for `capannot:"$Json.name(\"id\")"`.
+func capannotFromTag(tagLit string) string {
+	unquoted, err := strconv.Unquote(tagLit)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(reflect.StructTag(unquoted).Get("capannot"))
+}
+
+// StringList is a flag.Value collecting the values of a repeated
+// flag, like -using 'Json = import "/capnp/compat/json.capnp"'.
+type StringList []string
+
+func (l *StringList) String() string { return strings.Join(*l, "; ") }
+
+func (l *StringList) Set(v string) error {
+	*l = append(*l, strings.TrimSuffix(strings.TrimSpace(v), ";"))
+	return nil
+}
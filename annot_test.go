@@ -63,3 +63,49 @@ func TestLowercaseGoFieldsIgnoredByDefault(t *testing.T) {
 	})
 
 }
+
+func TestCapannotPassthrough(t *testing.T) {
+
+	cv.Convey("Given capannot field tags and // capannot: struct comments", t, func() {
+		cv.Convey("then the annotations should be written verbatim after the struct name and field types.", func() {
+			ex0 := "// Msg is a message.\n// capannot: $Json.discriminator(name = \"kind\")\n// capannot: $Team.owner(\"infra\")\n" +
+				"type Msg struct {\n" +
+				"ID int `capannot:\"$Json.name(\\\"id\\\")\"`\n" +
+				"Count int32 `capdefault:\"3\" capannot:\"$Team.audited\"`\n" +
+				"Plain string\n" +
+				"}"
+			cv.So(ExtractString2String(ex0), ShouldStartWithModuloWhiteSpace, `
+struct MsgCapn $Json.discriminator(name = "kind") $Team.owner("infra") {
+  iD @0: Int64 $Json.name("id");
+  count @1: Int32 = 3 $Team.audited;
+  plain @2: Text;
+}`)
+		})
+	})
+
+	cv.Convey("Given a struct comment that mentions capannot: in the middle of a line", t, func() {
+		cv.Convey("then only the lines that start with capannot: should be annotations.", func() {
+			ex0 := "// Msg is a message; see capannot: below for its JSON name.\n//\tcapannot: $Json.name(\"msg\")\n" +
+				"type Msg struct {\n" +
+				"Plain string\n" +
+				"}"
+			cv.So(ExtractString2String(ex0), ShouldStartWithModuloWhiteSpace, `
+struct MsgCapn $Json.name("msg") {
+  plain @0: Text;
+}`)
+		})
+	})
+
+	cv.Convey("Given -using and -file-annot", t, func() {
+		cv.Convey("then the schema header should carry the using lines and then the file annotations.", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			x.usings.Set(`Json = import "/capnp/compat/json.capnp";`)
+			x.fileAnnotations.Set(`$Go.doc("generated")`)
+			header := x.GenCapnpHeader().String()
+			cv.So(header, ShouldContainModuloWhiteSpace, `$Go.import("testpkg");
+using Json = import "/capnp/compat/json.capnp";
+$Go.doc("generated");`)
+		})
+	})
+}
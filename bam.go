@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -10,10 +12,13 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"unicode"
 )
 
@@ -25,8 +30,17 @@ type Extractor struct {
 	fieldPrefix string
 	fieldSuffix string
 
+	// where schema.capnp imports go.capnp from; see -go-capnp-import.
+	goCapnpImport string
+
+	// -using and -file-annot: extra using lines and file annotations
+	// for the schema header.
+	usings          StringList
+	fileAnnotations StringList
+
 	curStruct      *Struct
 	heldComment    string
+	heldDocLines   []string
 	extractPrivate bool
 
 	// map structs' goName <-> capName
@@ -40,15 +54,66 @@ type Extractor struct {
 	SaveCode   map[string][]byte
 	LoadCode   map[string][]byte
 
-	// key is CanonGoType(goTypeSeq)
+	// key is CanonGoType(goTypeSeq). GenerateTranslators renders each
+	// of listHelpers into SliceToListCode and ListToSliceCode.
+	listHelpers     map[string]*ListHelper
 	SliceToListCode map[string][]byte
 	ListToSliceCode map[string][]byte
 
+	// translators for the complex types, key is the go type; see noteComplex.
+	ComplexCode map[string][]byte
+	complexUsed map[string]bool
+
+	// key is goName for ViewCode, view type name for ListViewCode
+	ViewCode     map[string][]byte
+	ListViewCode map[string][]byte
+
+	// the text/template set that all generated Go comes from
+	tmpl *template.Template
+
 	compileDir *TempDir
 	outDir     string
 	srcFiles   []*SrcFile
 	overwrite  bool
 
+	// -include and -exclude struct name patterns, and the structs
+	// marked // bambam:ignore. See SelectStructs.
+	include StructPatternList
+	exclude StructPatternList
+	ignored map[string]bool
+
+	// -flatten: inline the fields of every embedded struct, as if
+	// each embed were tagged capid:"flatten".
+	flattenEmbedded bool
+
+	// -max-load-depth: how deep XCapnToGo may follow recursive
+	// structs; 0 for no limit.
+	maxLoadDepth int
+
+	// constants for the schema, by capnp name, and -consts: export
+	// every exported constant, not just those marked // bambam:const.
+	consts    map[string]*GoConst
+	allConsts bool
+
+	// every Go file read, parsed into fset, and the constants in them
+	// to export; see ExtractConsts.
+	fset        *token.FileSet
+	goFiles     []*ast.File
+	constIdents []constIdent
+
+	// named slice and map types, type IDs []int64, by name; see
+	// NoteNamedTypes.
+	namedTypes map[string]ast.Expr
+
+	// the PtrTCapn structs holding the elements of []*T fields, and
+	// the TCapn each holds; see ptrListType.
+	ptrWrappers map[string]string
+
+	// if set, e.g. to "json", the name in that struct tag picks the
+	// capnp field name, and a "-" there skips the field unless it has
+	// a capid.
+	nameFrom string
+
 	// fields for testing capid tagging
 	PubABC int `capid:"1"`
 	PubYXZ int `capid:"0"`
@@ -59,6 +124,7 @@ func NewExtractor() *Extractor {
 	return &Extractor{
 		pkgName:             "testpkg",
 		importDecl:          "testpkg",
+		goCapnpImport:       DefaultGoCapnpImport,
 		goType2capTypeCache: make(map[string]string),
 		capType2goType:      make(map[string]string),
 
@@ -70,8 +136,19 @@ func NewExtractor() *Extractor {
 		srs:             make(map[string]*Struct),
 		compileDir:      NewTempDir(),
 		srcFiles:        make([]*SrcFile, 0),
+		listHelpers:     make(map[string]*ListHelper),
 		SliceToListCode: make(map[string][]byte),
 		ListToSliceCode: make(map[string][]byte),
+		ViewCode:        make(map[string][]byte),
+		ComplexCode:     make(map[string][]byte),
+		complexUsed:     make(map[string]bool),
+		ListViewCode:    make(map[string][]byte),
+		ignored:         make(map[string]bool),
+		namedTypes:      make(map[string]ast.Expr),
+		ptrWrappers:     make(map[string]string),
+		consts:          make(map[string]*GoConst),
+		fset:            token.NewFileSet(),
+		tmpl:            mustLoadDefaultTemplates(),
 	}
 }
 
@@ -89,7 +166,6 @@ type Field struct {
 	goName       string
 	goType       string
 	goTypePrefix string
-	goToCapFunc  string
 
 	goTypeSeq      []string
 	capTypeSeq     []string
@@ -108,23 +184,90 @@ type Field struct {
 	singleCapListType          string
 	baseIsIntrinsic            bool
 	newListExpression          string
+
+	// flatten: an embedded struct whose fields should be inlined into
+	// the parent, by capid:"flatten" or (flattenByFlag) -flatten.
+	// FlattenEmbedded replaces it with copies of those fields, which
+	// have flattenedFrom set, and list in promoted the embedded
+	// pointers they are reached through.
+	flatten       bool
+	flattenByFlag bool
+	flattenedFrom string
+	promoted      []promotedPtr
+
+	// namedType is the field's Go type when that is a named slice
+	// type, like IDs for type IDs []int64; goType and goTypePrefix
+	// then describe the underlying []int64.
+	namedType string
+
+	// from a capdefault tag, as a capnp literal; see capnpDefaultLiteral.
+	defaultValue string
+
+	// from a capannot tag: capnp annotations, written verbatim after the type.
+	annotations string
+
+	// union is the capunion tag: the named capnp union this *T field
+	// is a member of. unionGroup is set by Struct.buildUnions.
+	union      string
+	unionGroup *Union
+
+	// keepNil is set by a capnil:"keep" tag on a []*T field, whose
+	// elements then go in PtrTCapn structs; see ptrListType.
+	keepNil bool
+
+	// Go doc and trailing line comment, for the schema
+	docLines    []string
+	lineComment string
+
+	// set by prepareStruct, for the templates
+	kind          string
+	listNum       int
+	firstListToGo bool
+	loadDepth     bool
+	toGoMayFail   bool
+	toCapnMayFail bool
 }
 
 type Struct struct {
-	capName              string
-	goName               string
-	fld                  []*Field
-	longestField         int
-	comment              string
-	capIdMap             map[int]*Field
-	firstNonTextListSeen bool
-	listNum              int
+	capName      string
+	goName       string
+	fld          []*Field
+	longestField int
+	comment      string
+	docLines     []string // the Go doc comment, for the schema
+	capIdMap     map[int]*Field
+
+	// recursive: s can reach itself through its fields; set by
+	// MarkRecursive. loadDepthLimit is -max-load-depth for such
+	// structs, and loadMayFail is true for those that can reach one;
+	// both are set by prepareStruct.
+	recursive      bool
+	loadDepthLimit int
+	loadMayFail    bool
+
+	// unions, from the capunion tags of fld, and whether saving can
+	// fail on one of them; set by prepareStruct.
+	unions      []*Union
+	saveMayFail bool
 }
 
 type SrcFile struct {
 	filename string
 	fset     *token.FileSet
 	astFile  *ast.File
+	src      []byte // the text astFile was parsed from
+
+	// where the capid tagged copy goes, relative to the output
+	// directory; filename if empty.
+	outName string
+}
+
+func (s *SrcFile) outPath(outDir string) string {
+	name := s.outName
+	if name == "" {
+		name = s.filename
+	}
+	return outDir + string(os.PathSeparator) + name
 }
 
 func (s *Struct) computeFinalOrder() {
@@ -204,48 +347,33 @@ func NewStruct(capName, goName string) *Struct {
 
 func (x *Extractor) GenerateTranslators() {
 
+	x.MarkRecursive()
 	for _, s := range x.srs {
-
-		x.SaveCode[s.goName] = []byte(fmt.Sprintf(`
-func (s *%s) Save(w io.Writer) error {
-  	seg := capn.NewBuffer(nil)
-  	%sGoToCapn(seg, s)
-    _, err := seg.WriteTo(w)
-    return err
-}
- `, s.goName, s.goName))
-
-		x.LoadCode[s.goName] = []byte(fmt.Sprintf(`
-func (s *%s) Load(r io.Reader) error {
-  	capMsg, err := capn.ReadFromStream(r, nil)
-  	if err != nil {
-  		//panic(fmt.Errorf("capn.ReadFromStream error: %%s", err))
-        return err
-  	}
-  	z := ReadRoot%s(capMsg)
-      %sToGo(z, s)
-   return nil
-}
-`, s.goName, s.capName, s.capName))
-
-		x.ToGoCode[s.goName] = []byte(fmt.Sprintf(`
-func %sToGo(src %s, dest *%s) *%s {
-  if dest == nil {
-    dest = &%s{}
-  }
-%s
-  return dest
-}
-`, s.capName, s.capName, s.goName, s.goName, s.goName, x.SettersToGo(s.goName)))
-
-		x.ToCapnCode[s.goName] = []byte(fmt.Sprintf(`
-func %sGoToCapn(seg *capn.Segment, src *%s) %s {
-  dest := AutoNew%s(seg)
-%s
-  return dest
-}
-`, s.goName, s.goName, s.capName, s.capName, x.SettersToCapn(s.goName)))
-
+		x.prepareStruct(s)
+
+		x.SaveCode[s.goName] = x.render("save", s)
+		x.LoadCode[s.goName] = x.render("load", s)
+		x.ToGoCode[s.goName] = x.render("toGo", s)
+		x.ToCapnCode[s.goName] = x.render("toCapn", s)
+	}
+	x.renderListHelpers()
+	x.GenerateComplexTranslators()
+}
+
+// renderListHelpers writes each of x.listHelpers into SliceToListCode
+// and ListToSliceCode, once all the structs are known and MarkRecursive
+// has run, which whether a helper can fail depends on.
+func (x *Extractor) renderListHelpers() {
+	x.SliceToListCode = make(map[string][]byte)
+	x.ListToSliceCode = make(map[string][]byte)
+	for canonGoType, h := range x.listHelpers {
+		h.ToCapnMayFail, h.ToGoMayFail = false, false
+		if t := x.srs[h.GoBaseType]; t != nil {
+			h.ToCapnMayFail = x.reachesUnion(t, make(map[string]bool))
+			h.ToGoMayFail = x.loadMayFail(t)
+		}
+		x.SliceToListCode[canonGoType] = x.render("sliceToList", h)
+		x.ListToSliceCode[canonGoType] = x.render("listToSlice", h)
 	}
 }
 
@@ -256,122 +384,6 @@ func (x *Extractor) packageDot() string {
 	return x.pkgName + "."
 }
 
-func (x *Extractor) SettersToGo(goName string) string {
-	var buf bytes.Buffer
-	myStruct := x.srs[goName]
-	if myStruct == nil {
-		panic(fmt.Sprintf("bad goName '%s'", goName))
-	}
-	VPrintf("\n\n SettersToGo running on myStruct = %#v\n", myStruct)
-	i := 0
-	for _, f := range myStruct.fld {
-		VPrintf("\n\n SettersToGo running on myStruct.fld[%d] = %#v\n", i, f)
-
-		n := len(f.goTypeSeq)
-
-		if n >= 2 && f.goTypeSeq[0] == "[]" {
-			x.SettersToGoListHelper(&buf, myStruct, f)
-		} else {
-
-			var isCapType bool = false
-			if _, ok := x.goType2capTypeCache[f.goTypeSeq[0]]; !ok {
-				if len(f.goTypeSeq) > 1 {
-					if _, ok := x.goType2capTypeCache[f.goTypeSeq[1]]; ok {
-						isCapType = true
-					}
-				}
-			} else {
-				isCapType = true
-			}
-
-			if isCapType {
-				if f.goTypeSeq[0] == "*" {
-					fmt.Fprintf(&buf, "  dest.%s = %sCapnToGo(src.%s(), nil)\n",
-						f.goName, f.goType, f.goCapGoName)
-				} else {
-					fmt.Fprintf(&buf, "  dest.%s = *%sCapnToGo(src.%s(), nil)\n",
-						f.goName, f.goType, f.goCapGoName)
-				}
-
-				continue
-			}
-
-			switch f.goType {
-			case "int":
-				fmt.Fprintf(&buf, "  dest.%s = int(src.%s())\n", f.goName, f.goCapGoName)
-
-			default:
-				fmt.Fprintf(&buf, "  dest.%s = src.%s()\n", f.goName, f.goCapGoName)
-			}
-		}
-		i++
-	}
-	return string(buf.Bytes())
-}
-
-func (x *Extractor) SettersToGoListHelper(buf io.Writer, myStruct *Struct, f *Field) {
-
-	VPrintf("\n in SettersToGoListHelper(): debug: field f = %#v\n", f)
-	VPrintf("\n in SettersToGoListHelper(): debug: myStruct = %#v\n", myStruct)
-
-	// special case Text / string slices
-	if f.capType == "List(Text)" {
-		fmt.Fprintf(buf, "  dest.%s = src.%s().ToArray()\n", f.goName, f.goCapGoName)
-		return
-	}
-	if !myStruct.firstNonTextListSeen {
-		fmt.Fprintf(buf, "\n  var n int\n")
-		myStruct.firstNonTextListSeen = true
-	}
-	// add a dereference (*) in from of the ToGo() invocation for go types that aren't pointers.
-	addStar := "*"
-	if isPointerType(f.goTypePrefix) {
-		addStar = ""
-	}
-
-	fmt.Fprintf(buf, `
-    // %s
-	n = src.%s().Len()
-	dest.%s = make(%s%s, n)
-	for i := 0; i < n; i++ {
-        dest.%s[i] = %s
-    }
-
-`, f.goName, f.goCapGoName, f.goName, f.goTypePrefix, f.goType, f.goName, x.ElemStarCapToGo(addStar, f))
-
-}
-
-func (x *Extractor) ElemStarCapToGo(addStar string, f *Field) string {
-	f.goToCapFunc = x.goToCapTypeFunction(f.capTypeSeq)
-
-	VPrintf("\n\n f = %#v   addStar = '%v'    f.goToCapFunc = '%s'\n", f, addStar, f.goToCapFunc)
-
-	// list of list special handling, try to generalize it, as it needs
-	// to work for intrinsics and structs
-	if f.goTypePrefix == "[][]" {
-		VPrintf("\n slice of slice / ListList detected.\n")
-		return fmt.Sprintf("%s(%s(src.%s().At(i)))", f.canonGoTypeListToSliceFunc, f.singleCapListType, f.goName)
-	}
-
-	if IsIntrinsicGoType(f.goType) {
-		VPrintf("\n intrinsic detected.\n")
-		return fmt.Sprintf("%s(src.%s().At(i))", f.goType, f.goName)
-	} else {
-		VPrintf("\n non-intrinsic detected. f.goType = '%v'\n", f.goType)
-		return fmt.Sprintf("%s%sToGo(src.%s().At(i), nil)", addStar, f.goToCapFunc, f.goName)
-	}
-}
-
-func (x *Extractor) goToCapTypeFunction(capTypeSeq []string) string {
-	var r string
-	for _, s := range capTypeSeq {
-		if s != "*" && s != "List" {
-			r = r + s
-		}
-	}
-	return r
-}
-
 func isPointerType(goTypePrefix string) bool {
 	if len(goTypePrefix) == 0 {
 		return false
@@ -391,132 +403,6 @@ func last(slc []string) string {
 	return slc[n-1]
 }
 
-func IsDoubleList(f *Field) bool {
-	if len(f.capTypeSeq) > 2 {
-		if f.capTypeSeq[0] == f.capTypeSeq[1] && f.capTypeSeq[1] == "List" {
-			return true
-		}
-	}
-	return false
-}
-
-func (x *Extractor) SettersToCapn(goName string) string {
-	var buf bytes.Buffer
-	t := x.srs[goName]
-	if t == nil {
-		panic(fmt.Sprintf("bad goName '%s'", goName))
-	}
-	VPrintf("\n\n SettersToCapn running on myStruct = %#v\n", t)
-	for i, f := range t.fld {
-		VPrintf("\n\n SettersToCapn running on t.fld[%d] = %#v\n", i, f)
-
-		if f.isList {
-			t.listNum++
-			if IsIntrinsicGoType(f.goType) {
-				VPrintf("\n intrinsic detected in SettersToCapn.\n")
-
-				if IsDoubleList(f) {
-					VPrintf("\n yes IsDoubleList(f: '%s') in SettersToCapn.\n", f.goName)
-
-					fmt.Fprintf(&buf, `
-
-  mylist%d := seg.NewPointerList(len(src.%s))
-  for i := range src.%s {
-     mylist%d.Set(i, capn.Object(%s(seg, src.%s[i])))
-  }
-  dest.Set%s(mylist%d)
-`, t.listNum, f.goName, f.goName, t.listNum, f.canonGoTypeSliceToListFunc, f.goName, f.goCapGoName, t.listNum)
-
-				} else {
-					VPrintf("\n\n  at non-List(List()), yes intrinsic list in SettersToCap(): f = %#v\n", f)
-					fmt.Fprintf(&buf, `
-
-  mylist%d := seg.New%sList(len(src.%s))
-  for i := range src.%s {
-     mylist%d.Set(i, %s(src.%s[i]))
-  }
-  dest.Set%s(mylist%d)
-`, t.listNum, last(f.capTypeSeq), f.goName, f.goName, t.listNum, last(f.goCapGoTypeSeq), f.goName, f.goCapGoName, t.listNum)
-				}
-			} else {
-				// handle list of struct
-				VPrintf("\n\n  at struct list in SettersToCap(): f = %#v\n", f)
-				addAmpersand := "&"
-				if isPointerType(f.goTypePrefix) {
-					addAmpersand = ""
-				}
-
-				// list of list needs special casing
-				if isListList(f.goTypePrefix) {
-					fmt.Fprintf(&buf, `
-  // %s -> %s (go slice to capn list)
-  if len(src.%s) > 0 {
-		plist := seg.NewPointerList(len(src.%s))
-		i := 0
-		for _, ele := range src.%s {
-			plist.Set(i, capn.Object(%s(seg, ele)))
-			i++
-		}
-		dest.Set%s(plist)
-	}
-`, f.goName, f.goToCapFunc, f.goName, f.goName, f.goName, f.canonGoTypeSliceToListFunc, f.goCapGoName)
-				} else {
-					fmt.Fprintf(&buf, `
-  // %s -> %s (go slice to capn list)
-  if len(src.%s) > 0 {
-		typedList := New%sList(seg, len(src.%s))
-		plist := capn.PointerList(typedList)
-		i := 0
-		for _, ele := range src.%s {
-			plist.Set(i, capn.Object(%sGoToCapn(seg, %sele)))
-			i++
-		}
-		dest.Set%s(typedList)
-	}
-`, f.goName, f.goToCapFunc, f.goName, f.goToCapFunc, f.goName, f.goName, f.goType, addAmpersand, f.goCapGoName)
-				}
-			} // end switch f.goType
-
-		} else {
-
-			var isCapType bool = false
-			if _, ok := x.goType2capTypeCache[f.goTypeSeq[0]]; !ok {
-				if len(f.goTypeSeq) > 1 {
-					if _, ok := x.goType2capTypeCache[f.goTypeSeq[1]]; ok {
-						isCapType = true
-					}
-				}
-			} else {
-				isCapType = true
-			}
-
-			if isCapType {
-				if f.goTypeSeq[0] == "*" {
-					fmt.Fprintf(&buf, "  dest.Set%s(%sGoToCapn(seg, src.%s))\n",
-						f.goName, f.goType, f.goName)
-				} else {
-					fmt.Fprintf(&buf, "  dest.Set%s(%sGoToCapn(seg, &src.%s))\n",
-						f.goName, f.goType, f.goName)
-				}
-
-				continue
-			}
-
-			switch f.goType {
-			case "int":
-				fmt.Fprintf(&buf, "  dest.Set%s(int64(src.%s))\n", f.goCapGoName, f.goName)
-			default:
-				fmt.Fprintf(&buf, "  dest.Set%s(src.%s)\n", f.goCapGoName, f.goName)
-			}
-		}
-	}
-	return string(buf.Bytes())
-}
-
-func isListList(goTypePrefix string) bool {
-	return strings.HasPrefix(goTypePrefix, "[][]")
-}
-
 func (x *Extractor) ToGoCodeFor(goName string) []byte {
 	return x.ToGoCode[goName]
 }
@@ -527,8 +413,12 @@ func (x *Extractor) ToCapnCodeFor(goStructName string) []byte {
 
 func (x *Extractor) WriteToSchema(w io.Writer) (n int64, err error) {
 
+	err = x.EvalConsts()
+	if err != nil {
+		return
+	}
+
 	var m int
-	var spaces string
 
 	// sort structs alphabetically to get a stable (testable) ordering.
 	sortedStructs := ByGoName(make([]*Struct, 0, len(x.srs)))
@@ -539,7 +429,12 @@ func (x *Extractor) WriteToSchema(w io.Writer) (n int64, err error) {
 
 	for _, s := range sortedStructs {
 
-		m, err = fmt.Fprintf(w, "%sstruct %s { %s", x.fieldSuffix, s.capName, x.fieldSuffix)
+		annots := structAnnotations(s.comment)
+		if annots != "" {
+			annots = " " + annots
+		}
+
+		m, err = fmt.Fprintf(w, "%s%sstruct %s%s { %s", x.fieldSuffix, schemaComment("", s.docLines), s.capName, annots, x.fieldSuffix)
 		n += int64(m)
 		if err != nil {
 			return
@@ -549,22 +444,39 @@ func (x *Extractor) WriteToSchema(w io.Writer) (n int64, err error) {
 
 		sort.Sort(ByFinalOrder(s.fld))
 
+		// a union's members go together in a "name :union { }" group,
+		// where its first member would be.
+		s.unions = s.buildUnions()
+
 		for i, fld := range s.fld {
 
 			VPrintf("\n\n debug in WriteToSchema(), fld = %#v\n", fld)
 
-			SetSpaces(&spaces, s.longestField, len(fld.capname))
-
-			capType, already := x.goType2capTypeCache[fld.goType]
-			if !already {
-				VPrintf("\n\n debug: setting capType = '%s', instead of '%s', already = false\n", fld.capType, capType)
-				capType = fld.capType
-			} else {
-				VPrintf("\n\n debug: already = true, capType = '%s'   fld.capType = %v\n", capType, fld.capType)
+			if fld.unionGroup == nil {
+				m, err = x.writeSchemaField(w, s, fld, i, x.fieldPrefix)
+				n += int64(m)
+				if err != nil {
+					return
+				}
+				continue
 			}
-
-			m, err = fmt.Fprintf(w, "%s%s  %s@%d: %s%s; %s", x.fieldPrefix, fld.capname, spaces, fld.finalOrder, ExtraSpaces(i), fld.capType, x.fieldSuffix)
-			//m, err = fmt.Fprintf(w, "%s%s  %s@%d: %s%s; %s", x.fieldPrefix, fld.capname, spaces, fld.finalOrder, ExtraSpaces(i), capType, x.fieldSuffix)
+			u := fld.UnionHead()
+			if u == nil {
+				continue
+			}
+			m, err = fmt.Fprintf(w, "%s%s :union { %s", x.fieldPrefix, u.Name, x.fieldSuffix)
+			n += int64(m)
+			if err != nil {
+				return
+			}
+			for _, mem := range u.Members {
+				m, err = x.writeSchemaField(w, s, mem, i, x.fieldPrefix+x.fieldPrefix)
+				n += int64(m)
+				if err != nil {
+					return
+				}
+			}
+			m, err = fmt.Fprintf(w, "%s} %s", x.fieldPrefix, x.fieldSuffix)
 			n += int64(m)
 			if err != nil {
 				return
@@ -580,28 +492,142 @@ func (x *Extractor) WriteToSchema(w io.Writer) (n int64, err error) {
 
 	} // end loop over structs
 
+	m64, err := x.writePtrWrappers(w)
+	n += m64
+	if err != nil {
+		return
+	}
+
+	m64, err = x.WriteComplexSchema(w)
+	n += m64
+	if err != nil {
+		return
+	}
+
+	m64, err = x.WriteConstSchema(w)
+	n += m64
 	return
 }
 
-func (x *Extractor) GenCapidTag(f *Field) string {
-	if f.astField == nil {
-		f.astField = &ast.Field{}
+// writeSchemaField writes fld's line of s's schema, indented by prefix.
+func (x *Extractor) writeSchemaField(w io.Writer, s *Struct, fld *Field, i int, prefix string) (int, error) {
+	var spaces string
+	SetSpaces(&spaces, s.longestField, len(fld.capname))
+
+	// a # comment runs to the end of the line, so end it with a newline
+	// whatever the fieldSuffix.
+	suffix := x.fieldSuffix
+	if fld.lineComment != "" {
+		suffix = "# " + fld.lineComment + "\n"
+	}
+
+	schemaType := fld.capType
+	if fld.defaultValue != "" {
+		schemaType += " = " + fld.defaultValue
 	}
-	if f.astField.Tag == nil {
-		f.astField.Tag = &ast.BasicLit{}
+	if fld.annotations != "" {
+		schemaType += " " + fld.annotations
 	}
 
-	curTag := f.astField.Tag.Value
+	return fmt.Fprintf(w, "%s%s%s  %s@%d: %s%s; %s", schemaComment(prefix, fld.docLines), prefix, fld.capname, spaces, fld.finalOrder, ExtraSpaces(i), schemaType, suffix)
+}
+
+// regexDirective matches the capname:"X" and capid comment lines,
+// which talk to bambam rather than document the type.
+var regexDirective = regexp.MustCompile(`^(capname|capid)[ \t]*:`)
 
+// isDirective reports whether the comment line talks to bambam: a
+// regexDirective, or one of the directives matched next to the code
+// that reads them.
+func isDirective(line string) bool {
+	return regexDirective.MatchString(line) ||
+		regexBambamDirective.MatchString(line) ||
+		regexCapannotDirective.MatchString(line)
+}
+
+// commentLines returns the text of cg, one string per line, without
+// the comment markers, Go directives like go:generate, or bambam's
+// own directives.
+func commentLines(cg *ast.CommentGroup) []string {
+	if cg == nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(cg.Text(), "\n"), "\n") {
+		if isDirective(strings.TrimSpace(line)) {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	// drop blank lines left at the ends by removed directives
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// schemaComment renders lines as capnp # comments, each on its own line.
+func schemaComment(indent string, lines []string) string {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line == "" {
+			fmt.Fprintf(&buf, "%s#\n", indent)
+		} else {
+			fmt.Fprintf(&buf, "%s# %s\n", indent, line)
+		}
+	}
+	return buf.String()
+}
+
+// capidTagLiteral returns the struct tag literal curTag with capid:"n"
+// added, or curTag itself if it already has a capid.
+func capidTagLiteral(curTag string, n int) string {
 	if hasCapidTag(curTag) {
 		return curTag
 	}
 	// else add one
-	addme := fmt.Sprintf(`capid:"%d"`, f.finalOrder)
-	if curTag == "" || curTag == "``" {
-		return fmt.Sprintf("`%s`", addme)
+	addme := fmt.Sprintf(`capid:"%d"`, n)
+	body := stripBackticks(curTag)
+	if unquoted, err := strconv.Unquote(curTag); err == nil {
+		// also right for a "json:\"id\"" tag
+		body = unquoted
+	}
+	tag := strings.TrimSpace(body + " " + addme)
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
+
+// nameFromTag returns the name given under key in the struct tag
+// literal tagLit, as in `json:"user_id,omitempty"`, or skip if that
+// name is "-".
+func nameFromTag(tagLit string, key string) (name string, skip bool) {
+	unquoted, err := strconv.Unquote(tagLit)
+	if err != nil {
+		return "", false
+	}
+	val, ok := reflect.StructTag(unquoted).Lookup(key)
+	if !ok {
+		return "", false
+	}
+	if val == "-" {
+		return "", true
 	}
-	return fmt.Sprintf("`%s %s`", stripBackticks(curTag), addme)
+	return strings.Split(val, ",")[0], false
+}
+
+// hasCapidNumber reports whether tagLit assigns a field number with capid.
+func hasCapidNumber(tagLit string) bool {
+	match := regexCapid.FindStringSubmatch(tagLit)
+	if match == nil {
+		return false
+	}
+	n, err := strconv.Atoi(match[1])
+	return err == nil && n >= 0
 }
 
 func hasCapidTag(s string) bool {
@@ -623,51 +649,66 @@ func stripBackticks(s string) string {
 	return string(r)
 }
 
+// CopySourceFilesAddCapidTag writes the capid tagged copy of each
+// source file into the output directory. Under -OVERWRITE it then
+// backs the originals up under bk/ there, as .go.bak files, and
+// replaces them. Every
+// file is tagged before any is written, and each is replaced
+// atomically, so an error leaves no source file half written.
 func (x *Extractor) CopySourceFilesAddCapidTag() error {
 
-	// run through struct fields, adding tags
-	for _, s := range x.srs {
-		for _, f := range s.fld {
-
-			VPrintf("\n\n\n ********** before  f.astField.Tag = %#v\n", f.astField.Tag)
-			f.astField.Tag.Value = x.GenCapidTag(f)
-			VPrintf("\n\n\n ********** AFTER:  f.astField.Tag = %#v\n", f.astField.Tag)
+	tagged := make([][]byte, len(x.srcFiles))
+	for i, s := range x.srcFiles {
+		if s.filename == "" {
+			continue
 		}
-	}
-
-	// run through files, printing
-	for _, s := range x.srcFiles {
-		if s.filename != "" {
-			err := x.PrettyPrint(s.fset, s.astFile, x.compileDir.DirPath+string(os.PathSeparator)+s.filename)
-			if err != nil {
-				return err
-			}
+		var err error
+		tagged[i], err = x.CapidTaggedSource(s)
+		if err != nil {
+			return err
 		}
 	}
 
-	if x.overwrite {
-		bk := fmt.Sprintf("%s%cbk%c", x.compileDir.DirPath, os.PathSeparator, os.PathSeparator)
-		err := os.MkdirAll(bk, 0755)
-		if err != nil {
-			panic(err)
+	for i, s := range x.srcFiles {
+		if s.filename == "" {
+			continue
 		}
-		for _, s := range x.srcFiles {
-			if s.filename != "" {
-				// make a backup
-				err := Cp(s.filename, bk+s.filename)
-				if err != nil {
-					panic(err)
-				}
-				// overwrite
-				err = Cp(x.compileDir.DirPath+string(os.PathSeparator)+s.filename, s.filename)
-				if err != nil {
-					panic(err)
-				}
+		dest := s.outPath(x.compileDir.DirPath)
+		if samePath(dest, s.filename) {
+			if !x.overwrite {
+				// e.g. go:generate, writing into the package itself.
+				fmt.Fprintf(os.Stderr, "bambam: not adding capid tags to '%s' in place; use -OVERWRITE for that.\n", s.filename)
 			}
+			continue
 		}
+		err := os.MkdirAll(filepath.Dir(dest), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(dest, tagged[i], 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
+	if !x.overwrite {
+		return nil
 	}
 
+	// make the backups before anything can write over the originals.
+	bk := filepath.Join(x.compileDir.DirPath, "bk")
+	err := x.backupSources(bk)
+	if err != nil {
+		return err
+	}
+	for i, s := range x.srcFiles {
+		if s.filename == "" {
+			continue
+		}
+		err := replaceSource(s, tagged[i])
+		if err != nil {
+			return fmt.Errorf("-OVERWRITE: %s (the originals are backed up under '%s')", err, bk)
+		}
+	}
 	return nil
 }
 
@@ -688,48 +729,24 @@ func (x *Extractor) WriteToTranslators(w io.Writer) (n int64, err error) {
 	// our test structure
 	for _, s := range sortedStructs {
 
-		m, err = fmt.Fprintf(w, "\n\n")
-		n += int64(m)
-		if err != nil {
-			return
-		}
-
 		m, err = w.Write(x.SaveCode[s.goName])
 		n += int64(m)
 		if err != nil {
 			return
 		}
 
-		m, err = fmt.Fprintf(w, "\n\n")
-		n += int64(m)
-		if err != nil {
-			return
-		}
-
 		m, err = w.Write(x.LoadCode[s.goName])
 		n += int64(m)
 		if err != nil {
 			return
 		}
 
-		m, err = fmt.Fprintf(w, "\n\n")
-		n += int64(m)
-		if err != nil {
-			return
-		}
-
 		m, err = w.Write(x.ToGoCodeFor(s.goName))
 		n += int64(m)
 		if err != nil {
 			return
 		}
 
-		m, err = fmt.Fprintf(w, "\n\n")
-		n += int64(m)
-		if err != nil {
-			return
-		}
-
 		m, err = w.Write(x.ToCapnCodeFor(s.goName))
 		n += int64(m)
 		if err != nil {
@@ -740,8 +757,13 @@ func (x *Extractor) WriteToTranslators(w io.Writer) (n int64, err error) {
 
 	// print the helpers made from x.GenerateListHelpers(capListTypeSeq, goTypeSeq)
 	// sort helper functions to get consistent (testable) order.
-	a := make([]AlphaHelper, len(x.SliceToListCode)+len(x.ListToSliceCode))
+	a := make([]AlphaHelper, len(x.SliceToListCode)+len(x.ListToSliceCode)+len(x.ComplexCode))
 	i := 0
+	for k, v := range x.ComplexCode {
+		a[i].Name = k
+		a[i].Code = v
+		i++
+	}
 	for k, v := range x.SliceToListCode {
 		a[i].Name = k
 		a[i].Code = v
@@ -757,12 +779,6 @@ func (x *Extractor) WriteToTranslators(w io.Writer) (n int64, err error) {
 
 	for _, help := range a {
 
-		m, err = fmt.Fprintf(w, "\n\n")
-		n += int64(m)
-		if err != nil {
-			return
-		}
-
 		m, err = w.Write(help.Code)
 		n += int64(m)
 		if err != nil {
@@ -831,7 +847,6 @@ func ExtractGoToCapnCode(src string, goName string) string {
 //
 // src has to be string, []byte, or io.Reader, as in parser.ParseFile(). src
 // can be nil if fname is provided. See http://golang.org/pkg/go/parser/#ParseFile
-//
 func ExtractStructs(fname string, src interface{}, x *Extractor) ([]byte, error) {
 	if x == nil {
 		x = NewExtractor()
@@ -843,17 +858,31 @@ func ExtractStructs(fname string, src interface{}, x *Extractor) ([]byte, error)
 
 func (x *Extractor) ExtractStructsFromOneFile(src interface{}, fname string) ([]byte, error) {
 
-	fset := token.NewFileSet() // positions are relative to fset
+	fset := x.fset // positions are relative to fset
+
+	var text []byte
+	if fname != "" {
+		// keep the text, for CapidTaggedSource to patch.
+		var err error
+		text, err = sourceBytes(fname, src)
+		if err != nil {
+			return []byte{}, err
+		}
+		src = text
+	}
 
 	f, err := parser.ParseFile(fset, fname, src, parser.ParseComments)
 	if err != nil {
-		panic(err)
+		return []byte{}, err
 	}
 
 	if fname != "" {
-		x.srcFiles = append(x.srcFiles, &SrcFile{filename: fname, fset: fset, astFile: f})
+		x.srcFiles = append(x.srcFiles, &SrcFile{filename: fname, fset: fset, astFile: f, src: text})
 	}
 
+	x.NoteNamedTypes(f)
+	x.ExtractConsts(f)
+
 	//	VPrintf("parsed output f.Decls is:\n")
 	//VPrintf("len(f.Decls) = %d\n", len(f.Decls))
 
@@ -869,14 +898,21 @@ func (x *Extractor) ExtractStructsFromOneFile(src interface{}, fname string) ([]
 				switch spe.(type) {
 				case (*ast.TypeSpec):
 
-					// go back and print the comments
-					if d.Doc != nil && d.Doc.List != nil && len(d.Doc.List) > 0 {
-						for _, com := range d.Doc.List {
+					typeSpec := spe.(*ast.TypeSpec)
+
+					// go back and print the comments. In a grouped
+					// type ( ... ) the doc is on the spec, not the decl.
+					doc := typeSpec.Doc
+					if doc == nil {
+						doc = d.Doc
+					}
+					x.heldComment = ""
+					x.heldDocLines = commentLines(doc)
+					if doc != nil && doc.List != nil && len(doc.List) > 0 {
+						for _, com := range doc.List {
 							x.GenerateComment(com.Text)
 						}
 					}
-
-					typeSpec := spe.(*ast.TypeSpec)
 					//VPrintf("\n\n *ast.TypeSpec spe = \n")
 
 					if typeSpec.Name.Obj.Kind == ast.Typ {
@@ -902,6 +938,14 @@ func (x *Extractor) ExtractStructsFromOneFile(src interface{}, fname string) ([]
 							case (*ast.StructType):
 								stru := ts2.Type.(*ast.StructType)
 
+								if regexIgnore.MatchString(x.heldComment) {
+									VPrintf("skipping struct '%s' marked // bambam:ignore\n", curStructName)
+									x.ignored[curStructName] = true
+									x.heldComment = ""
+									x.heldDocLines = nil
+									continue
+								}
+
 								err = x.StartStruct(curStructName)
 								if err != nil {
 									return []byte{}, err
@@ -948,10 +992,20 @@ func (x *Extractor) ExtractStructsFromOneFile(src interface{}, fname string) ([]
 														typeNamePrefix, ident4, gotypeseq := GetTypeAsString(fld2.Type, "", []string{})
 														//VPrintf("\n\n tnas = %#v, ident4 = %s\n", typeNamePrefix, ident4)
 
+														named := ""
+														typeNamePrefix, ident4, gotypeseq, named, err = x.ResolveNamedType(curStructName, ident.Name, typeNamePrefix, ident4, gotypeseq)
+														if err != nil {
+															return []byte{}, err
+														}
+
+														nfld := len(x.curStruct.fld)
 														err = x.GenerateStructField(ident.Name, typeNamePrefix, ident4, fld2, IsSlice(typeNamePrefix), fld2.Tag, NotEmbedded, gotypeseq)
 														if err != nil {
 															return []byte{}, err
 														}
+														if len(x.curStruct.fld) > nfld {
+															x.curStruct.fld[nfld].namedType = named
+														}
 													}
 												}
 											}
@@ -962,6 +1016,10 @@ func (x *Extractor) ExtractStructsFromOneFile(src interface{}, fname string) ([]
 
 								//VPrintf("} // end of %s \n\n", typeSpec.Name) // prod
 								x.EndStruct()
+								err = x.checkUnions(x.curStruct)
+								if err != nil {
+									return []byte{}, err
+								}
 
 								//goon.Dump(stru)
 								//VPrintf("\n =========== end stru =======\n\n\n")
@@ -1028,7 +1086,9 @@ func (x *Extractor) StartStruct(goName string) error {
 
 	x.curStruct = NewStruct(capname, goName)
 	x.curStruct.comment = x.heldComment
+	x.curStruct.docLines = x.heldDocLines
 	x.heldComment = ""
+	x.heldDocLines = nil
 	x.srs[goName] = x.curStruct
 
 	return nil
@@ -1092,6 +1152,10 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 	}
 
 	curField := &Field{orderOfAppearance: x.fieldCount, embedded: IsEmbedded, astField: astfld, goTypeSeq: goTypeSeq, capTypeSeq: []string{}}
+	if astfld != nil {
+		curField.docLines = commentLines(astfld.Doc)
+		curField.lineComment = strings.Join(commentLines(astfld.Comment), " ")
+	}
 
 	var tagValue string
 	loweredName := underToCamelCase(LowercaseCapnpFieldName(goFieldName))
@@ -1101,6 +1165,18 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 
 		if tag.Value != "" {
 
+			// e.g. json tag, under -name-from=json
+			if x.nameFrom != "" {
+				name, skip := nameFromTag(tag.Value, x.nameFrom)
+				if skip && !hasCapidNumber(tag.Value) {
+					VPrintf("skipping field '%s' marked with %s:\"-\"", goFieldName, x.nameFrom)
+					return nil
+				}
+				if name != "" {
+					loweredName = underToCamelCase(LowercaseCapnpFieldName(name))
+				}
+			}
+
 			// capname tag
 			match := regexCapname.FindStringSubmatch(tag.Value)
 			if match != nil {
@@ -1125,26 +1201,33 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 						VPrintf("skipping field '%s' marked with capid:\"skip\"", loweredName)
 						return nil
 					}
-					VPrintf("matched, applying capid tag '%s' for field '%s'\n", match2[1], loweredName)
-					n, err := strconv.Atoi(match2[1])
-					if err != nil {
-						err := fmt.Errorf(`problem in capid tag '%s' on field '%s' in struct '%s': could not convert to number, error: '%s'`, match2[1], goFieldName, x.curStruct.goName, err)
-						panic(err)
-						return err
-					}
-					if n < 0 {
-						VPrintf("skipping field '%s' marked with negative capid:\"%d\"", loweredName, n)
-						return nil
-					}
-					fld, already := x.curStruct.capIdMap[n]
-					if already {
-						err := fmt.Errorf(`problem in capid tag '%s' on field '%s' in struct '%s': number '%d' is already taken by field '%s'`, match2[1], goFieldName, x.curStruct.goName, n, fld.goName)
-						panic(err)
-						return err
-
+					if match2[1] == "flatten" {
+						if !IsEmbedded {
+							return fmt.Errorf(`problem in capid tag '%s' on field '%s' in struct '%s': only embedded structs can be flattened`, match2[1], goFieldName, x.curStruct.goName)
+						}
+						curField.flatten = true
 					} else {
-						x.curStruct.capIdMap[n] = curField
-						curField.capIdFromTag = n
+						VPrintf("matched, applying capid tag '%s' for field '%s'\n", match2[1], loweredName)
+						n, err := strconv.Atoi(match2[1])
+						if err != nil {
+							err := fmt.Errorf(`problem in capid tag '%s' on field '%s' in struct '%s': could not convert to number, error: '%s'`, match2[1], goFieldName, x.curStruct.goName, err)
+							panic(err)
+							return err
+						}
+						if n < 0 {
+							VPrintf("skipping field '%s' marked with negative capid:\"%d\"", loweredName, n)
+							return nil
+						}
+						fld, already := x.curStruct.capIdMap[n]
+						if already {
+							err := fmt.Errorf(`problem in capid tag '%s' on field '%s' in struct '%s': number '%d' is already taken by field '%s'`, match2[1], goFieldName, x.curStruct.goName, n, fld.goName)
+							panic(err)
+							return err
+
+						} else {
+							x.curStruct.capIdMap[n] = curField
+							curField.capIdFromTag = n
+						}
 					}
 				}
 			}
@@ -1152,6 +1235,12 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 
 	}
 
+	// under -flatten, an embed keeps its own ordinal only if it asks for one.
+	if IsEmbedded && x.flattenEmbedded && !curField.flatten && (tag == nil || !hasCapidNumber(tag.Value)) {
+		curField.flatten = true
+		curField.flattenByFlag = true
+	}
+
 	VPrintf("\n\n\n GenerateStructField: goFieldName:'%s' -> loweredName:'%s'\n\n", goFieldName, loweredName)
 
 	if isCapnpKeyword(loweredName) {
@@ -1161,6 +1250,19 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 
 	var capnTypeDisplayed string
 	curField.capTypeSeq, capnTypeDisplayed = x.GoTypeToCapnpType(curField, goTypeSeq)
+	if tag != nil {
+		switch c := capnilFromTag(tag.Value); c {
+		case "":
+		case "keep":
+			curField.keepNil = true
+		default:
+			return fmt.Errorf(`problem in capnil tag '%s' on field '%s' in struct '%s': the only capnil value is "keep"`, c, goFieldName, x.curStruct.goName)
+		}
+	}
+	capnTypeDisplayed, err := x.ptrListType(goTypeSeq, curField.capTypeSeq, capnTypeDisplayed, curField.keepNil)
+	if err != nil {
+		return fmt.Errorf("struct '%s': field '%s': %s", x.curStruct.goName, goFieldName, err)
+	}
 
 	VPrintf("\n\n\n DEBUG:  '%s' '%s' @%d: %s; %s\n\n", x.fieldPrefix, loweredName, x.fieldCount, capnTypeDisplayed, x.fieldSuffix)
 
@@ -1176,6 +1278,26 @@ func (x *Extractor) GenerateStructField(goFieldName string, goFieldTypePrefix st
 	curField.goCapGoTypeSeq, curField.goCapGoType = x.CapnTypeToGoType(curField.capTypeSeq)
 
 	curField.capType = capnTypeDisplayed
+
+	if tag != nil {
+		if val, ok := capdefaultFromTag(tag.Value); ok {
+			lit, err := capnpDefaultLiteral(curField.capType, val)
+			if err != nil {
+				return fmt.Errorf(`problem in capdefault tag '%s' on field '%s' in struct '%s': %s`, val, goFieldName, x.curStruct.goName, err)
+			}
+			curField.defaultValue = lit
+		}
+		curField.annotations = capannotFromTag(tag.Value)
+		if u := capunionFromTag(tag.Value); u != "" {
+			switch {
+			case !regexUnionName.MatchString(u) || isCapnpKeyword(u):
+				return fmt.Errorf(`problem in capunion tag '%s' on field '%s' in struct '%s': a union name must be a capnp identifier starting with a lowercase letter, and not a capnp keyword`, u, goFieldName, x.curStruct.goName)
+			case goFieldTypePrefix != "*" || IsIntrinsicGoType(goFieldTypeName) || complexPartTypes[goFieldTypeName] != "":
+				return fmt.Errorf(`problem in capunion tag '%s' on field '%s' in struct '%s': only pointer-to-struct fields, like *%s, can be in a capunion`, u, goFieldName, x.curStruct.goName, UppercaseFirstLetter(u))
+			}
+			curField.union = u
+		}
+	}
 	curField.goName = goFieldName
 	curField.goType = goFieldTypeName
 	if len(curField.capTypeSeq) > 0 && curField.capTypeSeq[0] == "List" {
@@ -1267,7 +1389,8 @@ func (x *Extractor) GoTypeToCapnpType(curField *Field, goTypeSeq []string) (capT
 	// currently only do List(primitive or struct type); no List(List(prim)) or List(List(struct))
 	n := len(capTypeSeq)
 	for i, ty := range capTypeSeq {
-		if ty == "List" && i == n-2 {
+		// a List(TCapn) holds a []T, or a []*T of structs.
+		if ty == "List" && (i == n-2 || i == n-3 && isStructPtr(goTypeSeq[i+1:])) {
 			VPrintf("\n\n generating List helpers at i=%d, capTypeSeq = '%#v\n", i, capTypeSeq)
 			x.GenerateListHelpers(curField, capTypeSeq[i:], goTypeSeq[i:])
 		}
@@ -1323,6 +1446,12 @@ func (x *Extractor) g2c(goFieldTypeName string) string {
 		return "Float64"
 	case "byte":
 		return "UInt8"
+	case "uint", "uintptr":
+		return "UInt64"
+	case "rune":
+		return "Int32"
+	case "complex64", "complex128":
+		return x.noteComplex(goFieldTypeName)
 	}
 
 	var capnTypeDisplayed string
@@ -1343,17 +1472,25 @@ func (x *Extractor) GenerateEmbedded(typeName string) {
 	fmt.Fprintf(&x.out, "%s; ", typeName) // prod
 }
 
+// getNewCapnpId returns a new file id from capnp id, like
+// @0xd1b7c6b6bdbd6b3e. Without the capnp tool, it makes one the same
+// way: 64 random bits, with the high bit set.
 func getNewCapnpId() string {
-	id, err := exec.Command("capnp", "id").CombinedOutput()
-	if err != nil {
-		panic(err)
-	}
-	n := len(id)
-	if n > 0 {
-		id = id[:n-1]
+	id, err := exec.Command("capnp", "id").Output()
+	if err != nil || !regexCapnpId.Match(bytes.TrimSpace(id)) {
+		return randomCapnpId()
 	}
+	return string(bytes.TrimSpace(id))
+}
+
+var regexCapnpId = regexp.MustCompile(`^@0x[0-9a-f]{16}$`)
 
-	return string(id)
+func randomCapnpId() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("@0x%016x", binary.BigEndian.Uint64(b[:])|1<<63)
 }
 
 func (x *Extractor) GenCapnpHeader() *bytes.Buffer {
@@ -1362,10 +1499,17 @@ func (x *Extractor) GenCapnpHeader() *bytes.Buffer {
 	id := getNewCapnpId()
 
 	fmt.Fprintf(&by, `%s;
-using Go = import "go.capnp";
+using Go = import "%s";
 $Go.package("%s");
 $Go.import("%s");
-%s`, id, x.pkgName, x.importDecl, x.fieldSuffix)
+`, id, x.goCapnpImport, x.pkgName, x.importDecl)
+	for _, u := range x.usings {
+		fmt.Fprintf(&by, "using %s;\n", u)
+	}
+	for _, a := range x.fileAnnotations {
+		fmt.Fprintf(&by, "%s;\n", a)
+	}
+	fmt.Fprintf(&by, "%s", x.fieldSuffix)
 
 	return &by
 }
@@ -1409,10 +1553,15 @@ func (x *Extractor) CapnpCompileFragment(in []byte) ([]byte, error) {
 	return compiled, nil
 }
 
+// CapnpCompilePath runs capnp compile -ogo on fname, which writes
+// fname.go next to it, and returns that file. It runs in fname's
+// directory, so the output lands there even for an absolute fname.
 func CapnpCompilePath(fname string) (generatedGoFile []byte, comboOut []byte, err error) {
 	goOutFn := fname + ".go"
 
-	by, err := exec.Command("capnp", "compile", "-ogo", fname).CombinedOutput()
+	cmd := exec.Command("capnp", "compile", "-ogo", filepath.Base(fname))
+	cmd.Dir = filepath.Dir(fname)
+	by, err := cmd.CombinedOutput()
 	if err != nil {
 		return []byte{}, by, err
 	}
@@ -1472,6 +1621,8 @@ func IsIntrinsicGoType(goFieldTypeName string) bool {
 		return true
 	case "byte":
 		return true
+	case "uint", "uintptr", "rune":
+		return true
 	default:
 		return false
 	}
@@ -1483,6 +1634,8 @@ func CanonGoType(goTypeSeq []string) string {
 	for _, s := range goTypeSeq {
 		if s == "[]" {
 			r += "Slice"
+		} else if s == "*" {
+			r += "Ptr"
 		} else {
 			r += UppercaseFirstLetter(s)
 		}
@@ -1508,6 +1661,12 @@ func (x *Extractor) GenerateListHelpers(f *Field, capListTypeSeq []string, goTyp
 
 	VPrintf("\n\n debug GenerateListHelper: called with capListTypeSeq = '%#v'\n", capListTypeSeq)
 
+	// the elements of a []*T are stored as the T they point to.
+	elemIsPointer := len(capListTypeSeq) == 3 && capListTypeSeq[1] == "*"
+	if elemIsPointer {
+		capListTypeSeq = []string{capListTypeSeq[0], capListTypeSeq[2]}
+	}
+
 	n := len(capListTypeSeq)
 	capBaseType := capListTypeSeq[n-1]
 	capTypeThenList := strings.Join(capListTypeSeq, "")
@@ -1537,41 +1696,18 @@ func (x *Extractor) GenerateListHelpers(f *Field, capListTypeSeq []string, goTyp
 	f.canonGoTypeListToSliceFunc = fmt.Sprintf("%sTo%s", capTypeThenList, canonGoType)
 	f.canonGoTypeSliceToListFunc = fmt.Sprintf("%sTo%s", canonGoType, capTypeThenList)
 
-	x.SliceToListCode[canonGoType] = []byte(fmt.Sprintf(`
-func %sTo%s(seg *capn.Segment, m %s) %s {
-	lst := %s
-	for i := range m {
-		lst.Set(i, %s)
+	x.listHelpers[canonGoType] = &ListHelper{
+		SliceToListFunc: f.canonGoTypeSliceToListFunc,
+		ListToSliceFunc: f.canonGoTypeListToSliceFunc,
+		GoType:          collapGoType,
+		GoBaseType:      goBaseType,
+		CapListType:     f.singleCapListType,
+		CapBaseType:     capBaseType,
+		CapGoBaseType:   c2g,
+		NewListExpr:     f.newListExpression,
+		BaseIsIntrinsic: f.baseIsIntrinsic,
+		ElemIsPointer:   elemIsPointer,
 	}
-	return lst
-}
-`, canonGoType, capTypeThenList, collapGoType, f.singleCapListType, f.newListExpression, x.SliceToListSetRHS(f.baseIsIntrinsic, goBaseType, c2g)))
-
-	x.ListToSliceCode[canonGoType] = []byte(fmt.Sprintf(`
-func %sTo%s(p %s) %s {
-	v := make(%s, p.Len())
-	for i := range v {
-        %s
-	}
-	return v
-}
-`, capTypeThenList, canonGoType, f.singleCapListType, collapGoType, collapGoType, x.ListToSliceSetLHS_RHS(f.baseIsIntrinsic, capBaseType, goBaseType)))
 
 	VPrintf("\n\n GenerateListHelpers done for field '%#v'\n\n", f)
 }
-
-func (x *Extractor) SliceToListSetRHS(baseIsIntrinsic bool, goName string, c2g string) string {
-	if baseIsIntrinsic {
-		return fmt.Sprintf("%s(m[i])", c2g)
-	} else {
-		return fmt.Sprintf("%sGoToCapn(seg, &m[i])", goName)
-	}
-}
-
-func (x *Extractor) ListToSliceSetLHS_RHS(baseIsIntrinsic bool, capName string, goBaseType string) string {
-	if baseIsIntrinsic {
-		return fmt.Sprintf("v[i] = %s(p.At(i))", goBaseType) // e.g. int64
-	} else {
-		return fmt.Sprintf("%sToGo(p.At(i), &v[i])", capName)
-	}
-}
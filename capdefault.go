@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// capdefaultFromTag returns the value of the capdefault key in the
+// struct tag literal tagLit, e.g. 10 for `capdefault:"10"`.
+func capdefaultFromTag(tagLit string) (val string, ok bool) {
+	unquoted, err := strconv.Unquote(tagLit)
+	if err != nil {
+		return "", false
+	}
+	return reflect.StructTag(unquoted).Lookup("capdefault")
+}
+
+// capnpDefaultLiteral checks that val is a value of the capnp type
+// capType, and returns it as a schema literal: 10 for an Int32, "hi"
+// for a Text of hi. Only Bool, numeric and Text fields take defaults.
+func capnpDefaultLiteral(capType string, val string) (string, error) {
+	switch capType {
+	case "Bool":
+		switch val {
+		case "true", "false":
+			return val, nil
+		}
+		return "", fmt.Errorf("'%s' is not a Bool; use true or false", val)
+
+	case "Int8", "Int16", "Int32", "Int64":
+		n, err := strconv.ParseInt(val, 0, capnpIntBits[capType])
+		if err != nil {
+			return "", fmt.Errorf("'%s' is not an %s", val, capType)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case "UInt8", "UInt16", "UInt32", "UInt64":
+		n, err := strconv.ParseUint(val, 0, capnpIntBits[capType])
+		if err != nil {
+			return "", fmt.Errorf("'%s' is not a %s", val, capType)
+		}
+		return strconv.FormatUint(n, 10), nil
+
+	case "Float32", "Float64":
+		bits := 64
+		if capType == "Float32" {
+			bits = 32
+		}
+		f, err := strconv.ParseFloat(val, bits)
+		if err != nil {
+			return "", fmt.Errorf("'%s' is not a %s", val, capType)
+		}
+		switch {
+		case math.IsNaN(f):
+			return "nan", nil
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		}
+		return strconv.FormatFloat(f, 'g', -1, bits), nil
+
+	case "Text":
+		return strconv.Quote(val), nil
+	}
+	return "", fmt.Errorf("only Bool, numeric and Text fields can have a default, not %s", capType)
+}
+
+// capnpIntBits gives the size of each capnp integer type.
+var capnpIntBits = map[string]int{
+	"Int8": 8, "Int16": 16, "Int32": 32, "Int64": 64,
+	"UInt8": 8, "UInt16": 16, "UInt32": 32, "UInt64": 64,
+}
+
+// Default is the field's capdefault, as a capnp literal, or empty.
+func (f *Field) Default() string { return f.defaultValue }
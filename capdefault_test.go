@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestCapdefaultTag(t *testing.T) {
+
+	cv.Convey("Given fields with capdefault tags", t, func() {
+		cv.Convey("then the schema should give them those defaults, as capnp literals", func() {
+			in := "type S struct {\n" +
+				"Count int32 `capdefault:\"10\"`\n" +
+				"Mask uint16 `capdefault:\"0xff\"`\n" +
+				"On bool `capdefault:\"true\"`\n" +
+				"Ratio float64 `capdefault:\"0.5\"`\n" +
+				"Name string `capdefault:\"say \\\"hi\\\"\"`\n" +
+				"Plain int\n" +
+				"}"
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+in, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct SCapn {
+  count @0: Int32 = 10;
+  mask @1: UInt16 = 255;
+  on @2: Bool = true;
+  ratio @3: Float64 = 0.5;
+  name @4: Text = "say \"hi\"";
+  plain @5: Int64;
+}`)
+			cv.So(len(ValidateCapnpFile("capdefault", schema.Bytes())), cv.ShouldEqual, 0)
+		})
+	})
+
+	cv.Convey("Given a capdefault that doesn't fit the field's type", t, func() {
+		cv.Convey("then extraction should fail, naming the field", func() {
+			for _, c := range []struct{ src, want string }{
+				{"type S struct { N int8 `capdefault:\"300\"` }", "problem in capdefault tag '300' on field 'N' in struct 'S': '300' is not an Int8"},
+				{"type S struct { B bool `capdefault:\"yes\"` }", "'yes' is not a Bool; use true or false"},
+				{"type S struct { L []int `capdefault:\"1\"` }", "only Bool, numeric and Text fields can have a default, not List(Int64)"},
+			} {
+				_, err := ExtractFromString(c.src)
+				cv.So(err == nil, cv.ShouldEqual, false)
+				cv.So(strings.Contains(err.Error(), c.want), cv.ShouldEqual, true)
+			}
+		})
+	})
+}
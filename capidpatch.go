@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// sourceBytes returns the text of the Go file fname, given src as
+// parser.ParseFile takes it: nil to read fname, or a string, []byte
+// or io.Reader holding the text.
+func sourceBytes(fname string, src interface{}) ([]byte, error) {
+	switch s := src.(type) {
+	case nil:
+		return ioutil.ReadFile(fname)
+	case string:
+		return []byte(s), nil
+	case []byte:
+		return s, nil
+	case io.Reader:
+		return ioutil.ReadAll(s)
+	}
+	return nil, fmt.Errorf("bambam: can't read Go source '%s' from a %T", fname, src)
+}
+
+// srcEdit replaces src[start:end] with text; start == end inserts.
+type srcEdit struct {
+	start, end int
+	text       string
+}
+
+// CapidTaggedSource returns the text of s with a capid tag on every
+// field bambam serializes. Only the tags change: a field that had
+// none gets one inserted after its type, and a field that had one
+// gets capid added to it. Every other byte, comments and formatting
+// included, stays as it was, except that fields declared together,
+// like A, B int, which can't share one capid, are split into one
+// declaration each. The result is parsed again, to be sure it is
+// still Go. WriteToSchema must have numbered the fields.
+func (x *Extractor) CapidTaggedSource(s *SrcFile) ([]byte, error) {
+
+	// the fields of s that we tag
+	inFile := make(map[*ast.Field]bool)
+	ast.Inspect(s.astFile, func(n ast.Node) bool {
+		if f, ok := n.(*ast.Field); ok {
+			inFile[f] = true
+		}
+		return true
+	})
+
+	tf := s.fset.File(s.astFile.Pos())
+	var edits []srcEdit
+
+	// the capid of each name of a field like A, B int, which gets split.
+	together := make(map[*ast.Field]map[string]int)
+
+	for _, st := range x.srs {
+		for _, f := range st.fld {
+			if f.flattenedFrom != "" || !inFile[f.astField] {
+				// a flattened field's tag lives in the embedded struct,
+				// with that struct's numbering.
+				continue
+			}
+			if len(f.astField.Names) > 1 {
+				if together[f.astField] == nil {
+					together[f.astField] = make(map[string]int)
+				}
+				together[f.astField][f.goName] = f.finalOrder
+				continue
+			}
+
+			old := f.astField.Tag
+			if old == nil {
+				at := tf.Offset(f.astField.Type.End())
+				edits = append(edits, srcEdit{start: at, end: at, text: " " + capidTagLiteral("", f.finalOrder)})
+				continue
+			}
+			if tag := capidTagLiteral(old.Value, f.finalOrder); tag != old.Value {
+				edits = append(edits, srcEdit{start: tf.Offset(old.Pos()), end: tf.Offset(old.End()), text: tag})
+			}
+		}
+	}
+
+	for af, orders := range together {
+		edits = append(edits, splitFieldEdit(tf, s.src, af, orders))
+	}
+
+	// apply from the end, so earlier offsets stay good.
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte{}, s.src...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+
+	_, err := parser.ParseFile(token.NewFileSet(), s.filename, out, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("bambam bug: adding capid tags to '%s' broke it: %s", s.filename, err)
+	}
+	return out, nil
+}
+
+// splitFieldEdit rewrites the field af, declared with several names,
+// as A int `capid:"4"`; B int `capid:"5"`, one declaration per name,
+// each with the capid in orders and the rest of af's tag. A name
+// missing from orders, one bambam doesn't serialize, keeps af's tag as
+// it was. The declarations go on lines of their own, indented as af
+// was, unless something else shares af's line.
+func splitFieldEdit(tf *token.File, src []byte, af *ast.Field, orders map[string]int) srcEdit {
+	start := tf.Offset(af.Names[0].Pos())
+	end := tf.Offset(af.Type.End())
+	if af.Tag != nil {
+		end = tf.Offset(af.Tag.End())
+	}
+	typ := string(src[tf.Offset(af.Type.Pos()):tf.Offset(af.Type.End())])
+
+	sep := "; "
+	lineStart := tf.Offset(tf.LineStart(tf.Line(af.Names[0].Pos())))
+	if indent := string(src[lineStart:start]); strings.TrimSpace(indent) == "" {
+		sep = "\n" + indent
+	}
+
+	decls := make([]string, len(af.Names))
+	for i, name := range af.Names {
+		decls[i] = name.Name + " " + typ
+		oldTag := ""
+		if af.Tag != nil {
+			oldTag = af.Tag.Value
+		}
+		if order, ok := orders[name.Name]; ok {
+			decls[i] += " " + capidTagLiteral(oldTag, order)
+		} else if oldTag != "" {
+			decls[i] += " " + oldTag
+		}
+	}
+	return srcEdit{start: start, end: end, text: strings.Join(decls, sep)}
+}
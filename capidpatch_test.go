@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestCapidTaggedSourceOnlyTouchesTags(t *testing.T) {
+
+	cv.Convey("Given a Go file with comments, odd spacing and existing tags", t, func() {
+		cv.Convey("then adding capid tags should change nothing but the tags, byte for byte, besides splitting A, B int in two", func() {
+
+			src := "package main\n\n" +
+				"// Rec is  a record.\n" +
+				"type Rec struct {\n" +
+				"\tID    int64 // the key\n" +
+				"\tName  string `json:\"name\"`\n" +
+				"\n" +
+				"\t/* odd */ Score float64 \"json:\\\"score\\\"\"\n" +
+				"\tTags  []string `capid:\"0\"`\n" +
+				"\tA, B  int\n" +
+				"}\n\n" +
+				"func   untouched( ) {  }\n"
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := x.ExtractStructsFromOneFile(src, "rec.go")
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			tagged, err := x.CapidTaggedSource(x.srcFiles[0])
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(tagged), cv.ShouldEqual, "package main\n\n"+
+				"// Rec is  a record.\n"+
+				"type Rec struct {\n"+
+				"\tID    int64 `capid:\"1\"` // the key\n"+
+				"\tName  string `json:\"name\" capid:\"2\"`\n"+
+				"\n"+
+				"\t/* odd */ Score float64 `json:\"score\" capid:\"3\"`\n"+
+				"\tTags  []string `capid:\"0\"`\n"+
+				"\tA int `capid:\"4\"`\n"+
+				"\tB int `capid:\"5\"`\n"+
+				"}\n\n"+
+				"func   untouched( ) {  }\n")
+		})
+
+		cv.Convey("then fields declared together on a line shared with others should be split with semicolons, keeping their tag", func() {
+			src := "package main\n\ntype P struct { X, y, Z float64 `json:\",omitempty\"`; N int }\n"
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := x.ExtractStructsFromOneFile(src, "p.go")
+			cv.So(err, cv.ShouldEqual, nil)
+			_, err = x.WriteToSchema(&bytes.Buffer{})
+			cv.So(err, cv.ShouldEqual, nil)
+
+			tagged, err := x.CapidTaggedSource(x.srcFiles[0])
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(tagged), cv.ShouldEqual, "package main\n\ntype P struct { "+
+				"X float64 `json:\",omitempty\" capid:\"0\"`; "+
+				"y float64 `json:\",omitempty\"`; "+
+				"Z float64 `json:\",omitempty\" capid:\"1\"`; "+
+				"N int `capid:\"2\"` }\n")
+		})
+	})
+}
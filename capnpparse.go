@@ -0,0 +1,612 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CapnpSchema is a parsed .capnp file: just enough of the Cap'n Proto
+// schema language to describe the data layout of its structs.
+type CapnpSchema struct {
+	Filename string
+	ID       string // e.g. 0xabcdef0123456789
+	Structs  []*CapnpStruct
+	Enums    []*CapnpEnum
+}
+
+// CapnpStruct is a struct declaration. Members of unions and groups
+// are listed in Fields along with the plain fields, tagged with the
+// union or group they belong to.
+type CapnpStruct struct {
+	Name    string
+	Line    int
+	Parent  *CapnpStruct // nil for top-level structs
+	Fields  []*CapnpField
+	Structs []*CapnpStruct // nested
+	Enums   []*CapnpEnum   // nested
+	Unions  []*CapnpGroup  // unnamed and named unions
+	Groups  []*CapnpGroup  // named groups
+}
+
+// CapnpGroup is a union or group inside a struct. An unnamed union has Name "".
+type CapnpGroup struct {
+	Name    string
+	IsUnion bool
+	Parent  *CapnpGroup // enclosing group or union, if any
+	Line    int
+}
+
+type CapnpField struct {
+	Name    string
+	Ordinal int
+	Type    *CapnpType
+	Default string // raw text after '=', if any
+	Line    int
+	Group   *CapnpGroup // innermost union or group holding this field; nil if none
+}
+
+// CapnpType is a (possibly List) type reference. For List(T), Name
+// is "List" and Elem is T.
+type CapnpType struct {
+	Name string
+	Elem *CapnpType
+}
+
+func (t *CapnpType) String() string {
+	if t.Elem != nil {
+		return t.Name + "(" + t.Elem.String() + ")"
+	}
+	return t.Name
+}
+
+type CapnpEnum struct {
+	Name       string
+	Line       int
+	Parent     *CapnpStruct
+	Enumerants []*CapnpEnumerant
+}
+
+type CapnpEnumerant struct {
+	Name    string
+	Ordinal int
+}
+
+// QualifiedName is the dotted name that refers to s from the top of
+// the file, e.g. Outer.Inner.
+func (s *CapnpStruct) QualifiedName() string {
+	if s.Parent == nil {
+		return s.Name
+	}
+	return s.Parent.QualifiedName() + "." + s.Name
+}
+
+func (e *CapnpEnum) QualifiedName() string {
+	if e.Parent == nil {
+		return e.Name
+	}
+	return e.Parent.QualifiedName() + "." + e.Name
+}
+
+// AllStructs returns the structs of the schema, nested ones right
+// after their parent.
+func (c *CapnpSchema) AllStructs() []*CapnpStruct {
+	var all []*CapnpStruct
+	var walk func(ss []*CapnpStruct)
+	walk = func(ss []*CapnpStruct) {
+		for _, s := range ss {
+			all = append(all, s)
+			walk(s.Structs)
+		}
+	}
+	walk(c.Structs)
+	return all
+}
+
+// AllEnums returns the enums of the schema, top-level and nested.
+func (c *CapnpSchema) AllEnums() []*CapnpEnum {
+	all := append([]*CapnpEnum{}, c.Enums...)
+	for _, s := range c.AllStructs() {
+		all = append(all, s.Enums...)
+	}
+	return all
+}
+
+// ParseCapnpSchema parses the text of a .capnp file. Declarations that
+// carry no struct data (interfaces, annotations, consts, using) are
+// skipped over. filename is only used in error messages.
+func ParseCapnpSchema(filename string, src []byte) (*CapnpSchema, error) {
+	toks, err := capnpTokenize(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	p := &capnpParser{fn: filename, src: src, toks: toks}
+	return p.parseFile()
+}
+
+const (
+	ctIdent = iota
+	ctNumber
+	ctString
+	ctPunct
+	ctEOF
+)
+
+type capnpToken struct {
+	kind int
+	text string
+	pos  int // byte offset in src
+	line int
+}
+
+func capnpTokenize(fn string, src []byte) ([]capnpToken, error) {
+	var toks []capnpToken
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				if i < len(src) && src[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("%s:%d: unterminated string", fn, line)
+			}
+			i++
+			toks = append(toks, capnpToken{kind: ctString, text: string(src[start:i]), pos: start, line: line})
+		case isCapnpIdentByte(c, true):
+			start := i
+			for i < len(src) && isCapnpIdentByte(src[i], false) {
+				i++
+			}
+			toks = append(toks, capnpToken{kind: ctIdent, text: string(src[start:i]), pos: start, line: line})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (isCapnpIdentByte(src[i], false) || src[i] == '.' ||
+				((src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E') && !strings.HasPrefix(string(src[start:i]), "0x"))) {
+				i++
+			}
+			toks = append(toks, capnpToken{kind: ctNumber, text: string(src[start:i]), pos: start, line: line})
+		case strings.IndexByte("{}()[];:=,.$@-><", c) >= 0:
+			toks = append(toks, capnpToken{kind: ctPunct, text: string(c), pos: i, line: line})
+			i++
+		default:
+			return nil, fmt.Errorf("%s:%d: unexpected character %q", fn, line, c)
+		}
+	}
+	toks = append(toks, capnpToken{kind: ctEOF, pos: len(src), line: line})
+	return toks, nil
+}
+
+func isCapnpIdentByte(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+type capnpParser struct {
+	fn   string
+	src  []byte
+	toks []capnpToken
+	i    int
+}
+
+func (p *capnpParser) peek() capnpToken { return p.toks[p.i] }
+
+func (p *capnpParser) next() capnpToken {
+	t := p.toks[p.i]
+	if t.kind != ctEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *capnpParser) errorf(t capnpToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.fn, t.line, fmt.Sprintf(format, args...))
+}
+
+// fieldAhead reports whether the member coming up is "name @N", a
+// field, even if name is a keyword such as struct or enum.
+func (p *capnpParser) fieldAhead() bool {
+	if p.i+1 >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.i+1]
+	return p.peek().kind == ctIdent && t.kind == ctPunct && t.text == "@"
+}
+
+func (p *capnpParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == ctPunct && t.text == s
+}
+
+func (p *capnpParser) expect(s string) error {
+	t := p.next()
+	if t.text != s || (t.kind != ctPunct && t.kind != ctIdent) {
+		return p.errorf(t, "expected '%s', found '%s'", s, t.text)
+	}
+	return nil
+}
+
+func (p *capnpParser) ident() (capnpToken, error) {
+	t := p.next()
+	if t.kind != ctIdent {
+		return t, p.errorf(t, "expected a name, found '%s'", t.text)
+	}
+	return t, nil
+}
+
+// ordinal parses "@N".
+func (p *capnpParser) ordinal() (int, error) {
+	if err := p.expect("@"); err != nil {
+		return 0, err
+	}
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != ctNumber || err != nil {
+		return 0, p.errorf(t, "bad ordinal '@%s'", t.text)
+	}
+	return n, nil
+}
+
+// skipAnnotations skips any number of $name or $name(value) uses.
+func (p *capnpParser) skipAnnotations() error {
+	for p.isPunct("$") {
+		p.next()
+		if _, err := p.ident(); err != nil {
+			return err
+		}
+		for p.isPunct(".") {
+			p.next()
+			if _, err := p.ident(); err != nil {
+				return err
+			}
+		}
+		if p.isPunct("(") {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipBalanced skips from an opening open through its matching close.
+func (p *capnpParser) skipBalanced(open, close string) error {
+	start := p.peek()
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == ctEOF:
+			return p.errorf(start, "no matching '%s' for this '%s'", close, open)
+		case t.kind == ctPunct && t.text == open:
+			depth++
+		case t.kind == ctPunct && t.text == close:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// skipStatement skips through the next ';' or balanced {...} block.
+func (p *capnpParser) skipStatement() error {
+	for {
+		switch {
+		case p.peek().kind == ctEOF:
+			return p.errorf(p.peek(), "unexpected end of file")
+		case p.isPunct(";"):
+			p.next()
+			return nil
+		case p.isPunct("{"):
+			return p.skipBalanced("{", "}")
+		case p.isPunct("("):
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		case p.isPunct("["):
+			if err := p.skipBalanced("[", "]"); err != nil {
+				return err
+			}
+		default:
+			p.next()
+		}
+	}
+}
+
+func (p *capnpParser) parseFile() (*CapnpSchema, error) {
+	c := &CapnpSchema{Filename: p.fn}
+	for p.peek().kind != ctEOF {
+		t := p.peek()
+		switch {
+		case t.kind == ctPunct && t.text == "@":
+			p.next()
+			id := p.next()
+			if id.kind != ctNumber {
+				return nil, p.errorf(id, "bad file id '@%s'", id.text)
+			}
+			c.ID = id.text
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case t.kind == ctPunct && t.text == "$":
+			if err := p.skipAnnotations(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case t.kind == ctIdent && t.text == "struct":
+			s, err := p.parseStruct(nil)
+			if err != nil {
+				return nil, err
+			}
+			c.Structs = append(c.Structs, s)
+		case t.kind == ctIdent && t.text == "enum":
+			e, err := p.parseEnum(nil)
+			if err != nil {
+				return nil, err
+			}
+			c.Enums = append(c.Enums, e)
+		case t.kind == ctIdent && (t.text == "using" || t.text == "const" || t.text == "annotation" || t.text == "interface"):
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf(t, "unexpected '%s' at top level", t.text)
+		}
+	}
+	return c, nil
+}
+
+// parseStruct parses "struct Name [@id] [$annot] { ... }".
+func (p *capnpParser) parseStruct(parent *CapnpStruct) (*CapnpStruct, error) {
+	p.next() // struct
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if p.isPunct("(") {
+		return nil, p.errorf(name, "struct %s: generic structs are not supported", name.text)
+	}
+	s := &CapnpStruct{Name: name.text, Line: name.line, Parent: parent}
+	if err := p.skipTypeIdAndAnnotations(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseMembers(s, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// skipTypeIdAndAnnotations skips an optional "@0x..." type id and any annotations.
+func (p *capnpParser) skipTypeIdAndAnnotations() error {
+	if p.isPunct("@") {
+		p.next()
+		p.next()
+	}
+	return p.skipAnnotations()
+}
+
+// parseMembers parses struct, union or group members up to and
+// including the closing '}'. grp is the union or group being parsed,
+// nil directly inside the struct.
+func (p *capnpParser) parseMembers(s *CapnpStruct, grp *CapnpGroup) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == ctEOF:
+			return p.errorf(t, "struct %s: missing '}'", s.Name)
+		case t.kind == ctPunct && t.text == "}":
+			p.next()
+			return nil
+		case p.fieldAhead():
+			if err := p.parseField(s, grp); err != nil {
+				return err
+			}
+		case t.kind == ctIdent && t.text == "struct":
+			n, err := p.parseStruct(s)
+			if err != nil {
+				return err
+			}
+			s.Structs = append(s.Structs, n)
+		case t.kind == ctIdent && t.text == "enum":
+			e, err := p.parseEnum(s)
+			if err != nil {
+				return err
+			}
+			s.Enums = append(s.Enums, e)
+		case t.kind == ctIdent && t.text == "union":
+			p.next()
+			u := &CapnpGroup{IsUnion: true, Parent: grp, Line: t.line}
+			s.Unions = append(s.Unions, u)
+			if err := p.skipTypeIdAndAnnotations(); err != nil {
+				return err
+			}
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseMembers(s, u); err != nil {
+				return err
+			}
+		case t.kind == ctIdent && (t.text == "using" || t.text == "const" || t.text == "annotation" || t.text == "interface"):
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		case t.kind == ctIdent:
+			if err := p.parseField(s, grp); err != nil {
+				return err
+			}
+		default:
+			return p.errorf(t, "struct %s: unexpected '%s'", s.Name, t.text)
+		}
+	}
+}
+
+// parseField parses "name @N :Type [= default] [$annot];", or a
+// named union or group: "name :union { ... }", "name :group { ... }".
+func (p *capnpParser) parseField(s *CapnpStruct, grp *CapnpGroup) error {
+	name, _ := p.ident()
+
+	if !p.isPunct("@") {
+		// named union or group
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		kind, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if kind.text != "union" && kind.text != "group" {
+			return p.errorf(kind, "field %s.%s has no ordinal", s.Name, name.text)
+		}
+		g := &CapnpGroup{Name: name.text, IsUnion: kind.text == "union", Parent: grp, Line: name.line}
+		if g.IsUnion {
+			s.Unions = append(s.Unions, g)
+		} else {
+			s.Groups = append(s.Groups, g)
+		}
+		if err := p.skipAnnotations(); err != nil {
+			return err
+		}
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		return p.parseMembers(s, g)
+	}
+
+	ord, err := p.ordinal()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return err
+	}
+	f := &CapnpField{Name: name.text, Ordinal: ord, Type: typ, Line: name.line, Group: grp}
+
+	if p.isPunct("=") {
+		p.next()
+		start := p.peek().pos
+		for !p.isPunct(";") && !p.isPunct("$") {
+			switch {
+			case p.peek().kind == ctEOF:
+				return p.errorf(name, "field %s.%s: unterminated default value", s.Name, name.text)
+			case p.isPunct("("):
+				err = p.skipBalanced("(", ")")
+			case p.isPunct("["):
+				err = p.skipBalanced("[", "]")
+			default:
+				p.next()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		f.Default = strings.TrimSpace(string(p.src[start:p.peek().pos]))
+	}
+	if err := p.skipAnnotations(); err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	s.Fields = append(s.Fields, f)
+	return nil
+}
+
+// parseType parses Name, Outer.Inner, or List(T).
+func (p *capnpParser) parseType() (*CapnpType, error) {
+	t, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	name := t.text
+	for p.isPunct(".") {
+		p.next()
+		n, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		name += "." + n.text
+	}
+	typ := &CapnpType{Name: name}
+	if p.isPunct("(") {
+		if name != "List" {
+			return nil, p.errorf(t, "generic type %s(...) is not supported", name)
+		}
+		p.next()
+		typ.Elem, err = p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	} else if name == "List" {
+		return nil, p.errorf(t, "List needs an element type, as in List(Int64)")
+	}
+	return typ, nil
+}
+
+// parseEnum parses "enum Name { a @0; b @1; }".
+func (p *capnpParser) parseEnum(parent *CapnpStruct) (*CapnpEnum, error) {
+	p.next() // enum
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	e := &CapnpEnum{Name: name.text, Line: name.line, Parent: parent}
+	if err := p.skipTypeIdAndAnnotations(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.isPunct("}") {
+		n, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		ord, err := p.ordinal()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.skipAnnotations(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		e.Enumerants = append(e.Enumerants, &CapnpEnumerant{Name: n.text, Ordinal: ord})
+	}
+	p.next()
+	return e, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// capnpBuiltinTypes are the type names the schema language provides,
+// besides List.
+var capnpBuiltinTypes = map[string]bool{
+	"Void": true, "Bool": true, "Text": true, "Data": true,
+	"Int8": true, "Int16": true, "Int32": true, "Int64": true,
+	"UInt8": true, "UInt16": true, "UInt32": true, "UInt64": true,
+	"Float32": true, "Float64": true,
+	"AnyPointer": true, "AnyStruct": true, "AnyList": true, "Capability": true,
+}
+
+// ValidateCapnpFile parses the .capnp text in src and checks it with
+// ValidateCapnpSchema. A schema that doesn't parse gives just the
+// parse error.
+func ValidateCapnpFile(filename string, src []byte) []error {
+	schema, err := ParseCapnpSchema(filename, src)
+	if err != nil {
+		return []error{err}
+	}
+	return ValidateCapnpSchema(schema)
+}
+
+// ValidateCapnpSchema checks schema for the mistakes that would make
+// capnp compile reject it, or that bambam's translators can't handle,
+// without needing the capnp tool:
+//
+//   - ordinals that repeat, or don't run 0..n-1 without gaps
+//   - member names that repeat in a struct, or in a named union or group
+//   - struct, enum and field names that are capnp keywords
+//   - field types that name no builtin, struct or enum in scope
+//   - lists nested deeper than bambam's [][]T
+//
+// It returns every problem found, in the order of the schema.
+func ValidateCapnpSchema(schema *CapnpSchema) []error {
+	v := &capnpValidator{
+		schema:  schema,
+		structs: make(map[string]bool),
+		enums:   make(map[string]bool),
+	}
+	for _, s := range schema.AllStructs() {
+		v.structs[s.QualifiedName()] = true
+	}
+	for _, e := range schema.AllEnums() {
+		v.enums[e.QualifiedName()] = true
+	}
+
+	for _, e := range schema.Enums {
+		v.checkEnum(e)
+	}
+	for _, s := range schema.AllStructs() {
+		v.checkStruct(s)
+	}
+	return v.errs
+}
+
+type capnpValidator struct {
+	schema  *CapnpSchema
+	structs map[string]bool // by qualified name
+	enums   map[string]bool
+	errs    []error
+}
+
+func (v *capnpValidator) errorf(line int, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s:%d: %s", v.schema.Filename, line, fmt.Sprintf(format, args...)))
+}
+
+func (v *capnpValidator) checkStruct(s *CapnpStruct) {
+	if isCapnpKeyword(s.Name) {
+		v.errorf(s.Line, "struct name '%s' is a capnp keyword", s.Name)
+	}
+	for _, e := range s.Enums {
+		v.checkEnum(e)
+	}
+
+	// a named union or group is a member of its scope, like a field.
+	byName := make(map[nameInScope]int) // line
+	var named []*CapnpGroup
+	named = append(named, s.Groups...)
+	named = append(named, s.Unions...)
+	for _, g := range named {
+		if g.Name == "" {
+			continue
+		}
+		key := nameInScope{memberScope(g.Parent), g.Name}
+		if line, dup := byName[key]; dup {
+			v.errorf(g.Line, "struct %s%s: name '%s' is already used on line %d", s.Name, scopeLabel(key.scope), g.Name, line)
+		} else {
+			byName[key] = g.Line
+		}
+	}
+
+	byOrdinal := make(map[int]*CapnpField)
+	for _, f := range s.Fields {
+		if isCapnpKeyword(f.Name) {
+			v.errorf(f.Line, "struct %s: field name '%s' is a capnp keyword", s.Name, f.Name)
+		}
+		key := nameInScope{memberScope(f.Group), f.Name}
+		if line, dup := byName[key]; dup {
+			v.errorf(f.Line, "struct %s%s: field name '%s' is already used on line %d", s.Name, scopeLabel(key.scope), f.Name, line)
+		} else {
+			byName[key] = f.Line
+		}
+		if g := byOrdinal[f.Ordinal]; g != nil {
+			v.errorf(f.Line, "struct %s: field '%s' has ordinal @%d, already taken by field '%s'", s.Name, f.Name, f.Ordinal, g.Name)
+		} else {
+			byOrdinal[f.Ordinal] = f
+		}
+		v.checkType(s, f, f.Type, 0)
+	}
+
+	ords := make([]int, 0, len(byOrdinal))
+	for n := range byOrdinal {
+		ords = append(ords, n)
+	}
+	sort.Ints(ords)
+	for i, n := range ords {
+		if n != i {
+			v.errorf(s.Line, "struct %s: ordinals must run 0..%d without gaps, but @%d is missing", s.Name, len(s.Fields)-1, i)
+			break
+		}
+	}
+}
+
+// nameInScope is a member name, in the struct or named group or union
+// whose members must have different names.
+type nameInScope struct {
+	scope *CapnpGroup // nil for the struct itself
+	name  string
+}
+
+// memberScope is the scope of the members of g: g itself, if it has a
+// name, or else the scope g is in, since the members of an unnamed
+// union belong to the struct or group around it, as capnp has it.
+func memberScope(g *CapnpGroup) *CapnpGroup {
+	for g != nil && g.Name == "" {
+		g = g.Parent
+	}
+	return g
+}
+
+// scopeLabel names the group or union scope for an error message,
+// or is empty for the struct itself.
+func scopeLabel(scope *CapnpGroup) string {
+	switch {
+	case scope == nil:
+		return ""
+	case scope.IsUnion:
+		return ", union " + scope.Name
+	}
+	return ", group " + scope.Name
+}
+
+func (v *capnpValidator) checkEnum(e *CapnpEnum) {
+	if isCapnpKeyword(e.Name) {
+		v.errorf(e.Line, "enum name '%s' is a capnp keyword", e.Name)
+	}
+	seen := make(map[int]string)
+	for _, en := range e.Enumerants {
+		if prev, ok := seen[en.Ordinal]; ok {
+			v.errorf(e.Line, "enum %s: enumerant '%s' has ordinal @%d, already taken by '%s'", e.Name, en.Name, en.Ordinal, prev)
+		} else {
+			seen[en.Ordinal] = en.Name
+		}
+	}
+	for i := range e.Enumerants {
+		if _, ok := seen[i]; !ok {
+			v.errorf(e.Line, "enum %s: ordinals must run 0..%d without gaps, but @%d is missing", e.Name, len(e.Enumerants)-1, i)
+			break
+		}
+	}
+}
+
+// checkType checks the type t of field f in struct s; depth counts
+// the enclosing Lists.
+func (v *capnpValidator) checkType(s *CapnpStruct, f *CapnpField, t *CapnpType, depth int) {
+	switch t.Name {
+	case "List":
+		if depth == 2 {
+			v.errorf(f.Line, "struct %s: field '%s' is %s, lists nested three deep; bambam handles at most [][]T", s.Name, f.Name, f.Type)
+			return
+		}
+		v.checkType(s, f, t.Elem, depth+1)
+		return
+	case "Data":
+		if depth == 2 {
+			v.errorf(f.Line, "struct %s: field '%s' is %s, lists nested three deep; bambam handles at most [][]T", s.Name, f.Name, f.Type)
+		}
+		return
+	}
+	if capnpBuiltinTypes[t.Name] {
+		return
+	}
+	q := lookupScoped(s, t.Name, func(q string) bool { return v.structs[q] || v.enums[q] })
+	if q == "" {
+		v.errorf(f.Line, "struct %s: field '%s' has unknown type '%s'", s.Name, f.Name, t.Name)
+	}
+}
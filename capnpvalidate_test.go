@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// schemaFor extracts the Go source file fn and returns the schema
+// bambam writes for it, minus the header.
+func schemaFor(fn string) []byte {
+	x := NewExtractor()
+	defer x.Cleanup()
+	_, err := ExtractStructs(fn, nil, x)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	_, err = x.WriteToSchema(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateGeneratedSchemas(t *testing.T) {
+
+	cv.Convey("Given the schemas bambam writes for the round-trip test sources", t, func() {
+		cv.Convey("then the pure Go validator should find nothing wrong with them", func() {
+			for _, fn := range []string{"rw.go.txt", "rw2.go.txt", "rw3.go.txt", "rw4.go.txt", "rw5.go.txt", "rw6.go.txt", "encoder.go.txt"} {
+				errs := ValidateCapnpFile(fn, schemaFor(fn))
+				cv.So(len(errs), cv.ShouldEqual, 0)
+			}
+		})
+	})
+}
+
+func TestCapnpIdWithoutCapnp(t *testing.T) {
+
+	cv.Convey("Given no capnp tool to ask for a file id", t, func() {
+		cv.Convey("then randomCapnpId should make a fresh id like capnp id does, with the high bit set", func() {
+			a, b := randomCapnpId(), randomCapnpId()
+			cv.So(regexCapnpId.MatchString(a), cv.ShouldEqual, true)
+			cv.So(a != b, cv.ShouldEqual, true)
+			cv.So(strings.IndexAny(a[3:4], "89abcdef"), cv.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestValidateCatchesBadSchemas(t *testing.T) {
+
+	cv.Convey("Given a schema with a duplicate ordinal, a keyword field name, an unknown type and lists nested three deep", t, func() {
+		cv.Convey("then ValidateCapnpFile should report each, with its line", func() {
+
+			src := `@0xabcdef0123456789;
+struct ACapn {
+   a      @0: Int64;
+   b      @0: Text;
+   struct @1: Bool;
+   c      @2: NoSuchCapn;
+   d      @3: List(List(List(Int64)));
+   e      @4: List(List(Data));
+}
+`
+			errs := ValidateCapnpFile("bad.capnp", []byte(src))
+			msgs := make([]string, len(errs))
+			for i, e := range errs {
+				msgs[i] = e.Error()
+			}
+			cv.So(strings.Join(msgs, "\n"), cv.ShouldEqual, `bad.capnp:4: struct ACapn: field 'b' has ordinal @0, already taken by field 'a'
+bad.capnp:5: struct ACapn: field name 'struct' is a capnp keyword
+bad.capnp:6: struct ACapn: field 'c' has unknown type 'NoSuchCapn'
+bad.capnp:7: struct ACapn: field 'd' is List(List(List(Int64))), lists nested three deep; bambam handles at most [][]T
+bad.capnp:8: struct ACapn: field 'e' is List(List(Data)), lists nested three deep; bambam handles at most [][]T`)
+		})
+	})
+
+	cv.Convey("Given a struct whose ordinals skip a number", t, func() {
+		cv.Convey("then the validator should name the missing ordinal", func() {
+
+			src := "struct ACapn {\n a @0: Int64;\n b @2: Text;\n}"
+			errs := ValidateCapnpFile("gap.capnp", []byte(src))
+			cv.So(len(errs), cv.ShouldEqual, 1)
+			cv.So(errs[0].Error(), cv.ShouldEqual, "gap.capnp:1: struct ACapn: ordinals must run 0..1 without gaps, but @1 is missing")
+		})
+	})
+
+	cv.Convey("Given a field whose type is a struct nested in an enclosing struct", t, func() {
+		cv.Convey("then the validator should find it in scope", func() {
+
+			src := "struct Outer { struct Inner { x @0: Int8; } enum Color { red @0; blue @1; } i @0: Inner; c @1: Color; }"
+			cv.So(len(ValidateCapnpFile("scope.capnp", []byte(src))), cv.ShouldEqual, 0)
+		})
+	})
+
+	cv.Convey("Given member names that repeat in different named groups and unions", t, func() {
+		cv.Convey("then the validator should accept them, as capnp does", func() {
+
+			src := `struct ACapn {
+   id @0: Int64;
+   home :group { street @1: Text; city @2: Text; }
+   work :group { street @3: Text; city @4: Text; }
+   outcome :union { ok @5: Int64; err @6: Text; }
+   retry :union { ok @7: Bool; err @8: Text; }
+}`
+			cv.So(len(ValidateCapnpFile("groups.capnp", []byte(src))), cv.ShouldEqual, 0)
+		})
+
+		cv.Convey("then a name that repeats in one scope should still be reported, an unnamed union's members being in the struct's scope", func() {
+
+			src := `struct ACapn {
+   id @0: Int64;
+   union { id @1: Text; other @2: Bool; }
+   home :group { street @3: Text; street @4: Text; }
+   other :group { x @5: Int8; }
+}`
+			errs := ValidateCapnpFile("dups.capnp", []byte(src))
+			msgs := make([]string, len(errs))
+			for i, e := range errs {
+				msgs[i] = e.Error()
+			}
+			cv.So(strings.Join(msgs, "\n"), cv.ShouldEqual, `dups.capnp:3: struct ACapn: field name 'id' is already used on line 2
+dups.capnp:3: struct ACapn: field name 'other' is already used on line 5
+dups.capnp:4: struct ACapn, group home: field name 'street' is already used on line 4`)
+		})
+	})
+}
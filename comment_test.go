@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestDocCommentsCarriedIntoSchema(t *testing.T) {
+
+	cv.Convey("Given a struct with a doc comment, and fields with doc and trailing comments", t, func() {
+		cv.Convey("then the schema should carry them as # comments, leaving out the capname directive", func() {
+
+			ex0 := `
+// Job is one unit of work.
+//
+// capname:"Job"
+type job struct {
+	// Queue is where the job runs.
+	Queue int
+	Name string // human readable
+	Tries int
+}`
+			cv.So(ExtractString2String(ex0), ShouldStartWithModuloWhiteSpace, `
+# Job is one unit of work.
+struct Job {
+  # Queue is where the job runs.
+  queue @0: Int64;
+  name @1: Text; # human readable
+  tries @2: Int64;
+}`)
+		})
+	})
+
+	cv.Convey("Given doc comments holding capannot: and bambam: directives", t, func() {
+		cv.Convey("then the schema comments should leave those lines out too", func() {
+
+			ex0 := `
+// Ev is an event.
+// capannot: $Foo.bar
+type Ev struct {
+	// At is when it happened.
+	// bambam: reserved
+	At int
+}`
+			cv.So(ExtractString2String(ex0), ShouldStartWithModuloWhiteSpace, `
+# Ev is an event.
+struct EvCapn $Foo.bar {
+  # At is when it happened.
+  at @0: Int64;
+}`)
+		})
+	})
+
+	cv.Convey("Given two structs in one grouped type declaration", t, func() {
+		cv.Convey("then each should get its own doc comment, and not its neighbour's", func() {
+
+			ex0 := `
+type (
+	// A is first.
+	A struct { X int }
+
+	B struct { Y int }
+)`
+			out := ExtractString2String(ex0)
+			cv.So(out, ShouldStartWithModuloWhiteSpace, `# A is first. struct ACapn { x @0: Int64; } struct BCapn { y @0: Int64; }`)
+		})
+	})
+}
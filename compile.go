@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// CompileSchema runs capnp compile -ogo on the schema.capnp in dir,
+// writing schema.capnp.go next to translateCapn.go.
+func CompileSchema(dir string) error {
+	_, out, err := CapnpCompilePath(filepath.Join(dir, "schema.capnp"))
+	if err != nil {
+		return fmt.Errorf("capnp compile -ogo schema.capnp in '%s': %s\n%s", dir, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// TypeCheckDir type-checks the package in dir, _test.go files aside,
+// and returns every error go/types finds. After CompileSchema, these
+// are the places where the translators call accessors that the
+// capnpc-go bindings don't have, or with the wrong types. Imports are
+// type-checked from source, so they must be where go build would
+// find them.
+func TypeCheckDir(dir string) ([]error, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, fn := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, fn), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	var errs []error
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(err error) { errs = append(errs, err) },
+	}
+	conf.Check(pkg.ImportPath, fset, files, nil)
+	return errs, nil
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	cv "github.com/glycerine/goconvey/convey"
@@ -26,6 +31,19 @@ type in1 struct {
 			//expect1 := `struct In1 { str @0: Text; n @1: Int64; d @2: Float64; } `
 			//cv.So(string(s1), cv.ShouldEqual, expect1)
 
+			// the pure Go validator needs no capnp tool
+			cv.So(len(ValidateCapnpFile("in1", s1)), cv.ShouldEqual, 0)
+		})
+	})
+
+	skipWithoutTools(t, "capnp")
+
+	cv.Convey("Given the capnp tool, and a simple struct", t, func() {
+		cv.Convey("then the capnp code we generate should compile", func() {
+
+			s1, err := ExtractFromString("type in1 struct { Str string; N int; D float64 }")
+			cv.So(err, cv.ShouldEqual, nil)
+
 			// no news on compile is good news
 			_, err, x := CapnpCompileFragment(s1)
 			cv.So(err, cv.ShouldEqual, nil)
@@ -34,3 +52,44 @@ type in1 struct {
 		})
 	})
 }
+
+// skipWithoutTools skips the rest of the test unless every one of
+// tools, like capnp or capnpc, is on the PATH. bambam itself needs
+// neither: it checks the schemas it writes with ValidateCapnpFile,
+// and makes file ids without capnp id. So a test should generate and
+// validate its output before skipping the steps that compile it.
+func skipWithoutTools(t *testing.T, tools ...string) {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed; skipping the steps that need it", tool)
+		}
+	}
+}
+
+func TestTypeCheckReportsMismatchedAccessors(t *testing.T) {
+
+	cv.Convey("Given an output package whose translators call an accessor the bindings don't have", t, func() {
+		cv.Convey("then TypeCheckDir should report the call", func() {
+
+			dir, err := ioutil.TempDir("", "bambam-typecheck")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			bindings := "package main\n\ntype UCapn struct{}\n\nfunc (s UCapn) N() int64 { return 0 }\n"
+			translators := "package main\n\nfunc UCapnToGo(src UCapn) int64 { return src.Name() }\n"
+			cv.So(ioutil.WriteFile(filepath.Join(dir, "schema.capnp.go"), []byte(bindings), 0644), cv.ShouldEqual, nil)
+			cv.So(ioutil.WriteFile(filepath.Join(dir, "translateCapn.go"), []byte(translators), 0644), cv.ShouldEqual, nil)
+
+			errs, err := TypeCheckDir(dir)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(errs), cv.ShouldEqual, 1)
+			cv.So(strings.Contains(errs[0].Error(), "src.Name undefined"), cv.ShouldEqual, true)
+
+			translators = "package main\n\nfunc UCapnToGo(src UCapn) int64 { return src.N() }\n"
+			cv.So(ioutil.WriteFile(filepath.Join(dir, "translateCapn.go"), []byte(translators), 0644), cv.ShouldEqual, nil)
+			errs, err = TypeCheckDir(dir)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(errs), cv.ShouldEqual, 0)
+		})
+	})
+}
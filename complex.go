@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// complexPartTypes gives, for each Go complex type, the capnp type of
+// its real and imaginary parts. capnp has no complex numbers, so a
+// complex128 is stored as a struct Complex128Capn { re, im }.
+var complexPartTypes = map[string]string{
+	"complex64":  "Float32",
+	"complex128": "Float64",
+}
+
+// noteComplex records that a field uses the complex type goType, so
+// that its struct goes into the schema and its translators into
+// translateCapn.go, and returns the struct's name.
+//
+// The translators follow the naming of those for a Go struct,
+// Complex128CapnToGo and Complex128GoToCapn, so a complex128 field,
+// and a []complex128, are translated like a struct field and a slice
+// of structs.
+func (x *Extractor) noteComplex(goType string) string {
+	capName := GoType2CapnType(goType)
+	x.complexUsed[goType] = true
+	x.goType2capTypeCache[goType] = capName
+	x.capType2goType[capName] = goType
+	return capName
+}
+
+// complexTypesUsed lists the complex types that fields use, sorted.
+func (x *Extractor) complexTypesUsed() []string {
+	var used []string
+	for t := range x.complexUsed {
+		used = append(used, t)
+	}
+	sort.Strings(used)
+	return used
+}
+
+// WriteComplexSchema writes the struct for each complex type in use.
+func (x *Extractor) WriteComplexSchema(w io.Writer) (n int64, err error) {
+	for _, t := range x.complexTypesUsed() {
+		part := complexPartTypes[t]
+		m, err := fmt.Fprintf(w, "%sstruct %s { %s%sre  @0: %s; %s%sim  @1: %s; %s} %s",
+			x.fieldSuffix, GoType2CapnType(t), x.fieldSuffix,
+			x.fieldPrefix, part, x.fieldSuffix,
+			x.fieldPrefix, part, x.fieldSuffix, x.fieldSuffix)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ComplexData is dot for the complex template.
+type ComplexData struct {
+	GoType  string // complex128
+	CapName string // Complex128Capn
+}
+
+// GenerateComplexTranslators renders the translators for each complex
+// type in use into x.ComplexCode.
+func (x *Extractor) GenerateComplexTranslators() {
+	for _, t := range x.complexTypesUsed() {
+		x.ComplexCode[t] = x.render("complex", &ComplexData{GoType: t, CapName: GoType2CapnType(t)})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestMoreBuiltinTypes(t *testing.T) {
+
+	cv.Convey("Given fields of type uint, uintptr and rune", t, func() {
+		cv.Convey("then they should map to UInt64 and Int32, with conversions like int's", func() {
+			in := `type S struct { U uint; P uintptr; R rune; Us []uint }`
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+in, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct SCapn { u @0: UInt64; p @1: UInt64; r @2: Int32; us @3: List(UInt64); }`)
+
+			x.GenerateTranslators()
+			toGo := string(x.ToGoCodeFor("S"))
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.U = uint(src.U()) dest.P = uintptr(src.P()) dest.R = src.R()`)
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.Us[i] = uint(src.Us().At(i))`)
+			cv.So(string(x.ToCapnCodeFor("S")), ShouldContainModuloWhiteSpace, `dest.SetU(uint64(src.U)) dest.SetP(uint64(src.P)) dest.SetR(src.R)`)
+		})
+	})
+
+	cv.Convey("Given fields of complex types", t, func() {
+		cv.Convey("then the schema should store them as { re, im } structs, translated like struct fields", func() {
+			in := `type S struct { C complex128; Cs []complex64 }`
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+in, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(ValidateCapnpFile("complex", schema.Bytes())), cv.ShouldEqual, 0)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `
+struct SCapn { c @0: Complex128Capn; cs @1: List(Complex64Capn); }
+struct Complex128Capn { re @0: Float64; im @1: Float64; }
+struct Complex64Capn { re @0: Float32; im @1: Float32; }`)
+
+			var tr bytes.Buffer
+			_, err = x.WriteToTranslators(&tr)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(tr.String(), ShouldContainModuloWhiteSpace, `dest.C = *Complex128CapnToGo(src.C(), nil)`)
+			cv.So(tr.String(), ShouldContainModuloWhiteSpace, `dest.SetC(Complex128GoToCapn(seg, &src.C))`)
+			cv.So(tr.String(), ShouldContainModuloWhiteSpace, `
+func Complex64CapnToGo(src Complex64Capn, dest *complex64) *complex64 {
+	if dest == nil {
+		dest = new(complex64)
+	}
+	*dest = complex(src.Re(), src.Im())
+	return dest
+}
+
+func Complex64GoToCapn(seg *capn.Segment, src *complex64) Complex64Capn {
+	dest := AutoNewComplex64Capn(seg)
+	dest.SetRe(real(*src))
+	dest.SetIm(imag(*src))
+	return dest
+}`)
+		})
+	})
+}
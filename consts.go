@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// regexConst matches the // bambam:const directive, on a const
+// declaration or on one constant of a const ( ... ) group.
+var regexConst = regexp.MustCompile(`bambam:const\b`)
+
+// GoConst is a Go constant exported to the schema as a capnp const.
+type GoConst struct {
+	goName  string
+	capName string // goName with its first letter lowercased
+	capType string // e.g. Int64, Text
+	value   string // as a capnp literal
+}
+
+// constIdent is a constant to export, and whether it was marked
+// // bambam:const, rather than picked up by -consts.
+type constIdent struct {
+	ident  *ast.Ident
+	marked bool
+}
+
+// ExtractConsts notes the constants of f marked // bambam:const, or
+// under -consts all its exported package-level constants, and f
+// itself, for EvalConsts to work out their values once every input
+// file has been read.
+func (x *Extractor) ExtractConsts(f *ast.File) {
+	x.goFiles = append(x.goFiles, f)
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range d.Specs {
+			vs := spec.(*ast.ValueSpec)
+			marked := regexConst.MatchString(d.Doc.Text()) || regexConst.MatchString(vs.Doc.Text())
+			for _, ident := range vs.Names {
+				if ident.Name != "_" && (marked || (x.allConsts && ident.IsExported())) {
+					x.constIdents = append(x.constIdents, constIdent{ident: ident, marked: marked})
+				}
+			}
+		}
+	}
+}
+
+// EvalConsts works out the values of the constants ExtractConsts
+// noted, for WriteConstSchema. The input files are type-checked
+// together, a package at a time, with their imports type-checked from
+// source, so a constant may use another input file's declarations, or
+// an import's, like time.Second. A marked constant that has no capnp
+// equivalent is an error; one that only -consts picked up is skipped,
+// with a warning.
+func (x *Extractor) EvalConsts() error {
+	if len(x.constIdents) == 0 {
+		return nil
+	}
+
+	var pkgNames []string
+	pkgFiles := make(map[string][]*ast.File)
+	for _, f := range x.goFiles {
+		if _, seen := pkgFiles[f.Name.Name]; !seen {
+			pkgNames = append(pkgNames, f.Name.Name)
+		}
+		pkgFiles[f.Name.Name] = append(pkgFiles[f.Name.Name], f)
+	}
+
+	// type errors elsewhere, like an import that can't be found,
+	// don't stop us; a constant they affect just has no value.
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{
+		Importer: importer.ForCompiler(x.fset, "source", nil),
+		Error:    func(error) {},
+	}
+	for _, name := range pkgNames {
+		conf.Check(name, x.fset, pkgFiles[name], info)
+	}
+
+	for _, ci := range x.constIdents {
+		ident := ci.ident
+		c, err := x.goConst(ident, info)
+		if err != nil && !ci.marked {
+			fmt.Fprintf(os.Stderr, "bambam: warning: %s: skipping const '%s': %s\n", x.fset.Position(ident.Pos()), ident.Name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: const '%s': %s", x.fset.Position(ident.Pos()), ident.Name, err)
+		}
+		if prev, dup := x.consts[c.capName]; dup {
+			return fmt.Errorf("%s: const '%s' becomes capnp const '%s', already used by const '%s'", x.fset.Position(ident.Pos()), ident.Name, c.capName, prev.goName)
+		}
+		x.consts[c.capName] = c
+	}
+	x.constIdents = nil
+	return nil
+}
+
+// goConst works out the capnp type and literal for the Go constant ident.
+func (x *Extractor) goConst(ident *ast.Ident, info *types.Info) (*GoConst, error) {
+	obj, ok := info.Defs[ident].(*types.Const)
+	if !ok || obj.Val().Kind() == constant.Unknown {
+		return nil, fmt.Errorf("can't work out its value from the input files and their imports")
+	}
+	capName := LowercaseCapnpFieldName(ident.Name)
+	if isCapnpKeyword(capName) {
+		return nil, fmt.Errorf("'%s' is a reserved capnp word", capName)
+	}
+	basic, ok := obj.Type().Underlying().(*types.Basic)
+	if !ok {
+		return nil, fmt.Errorf("its type %s has no capnp equivalent", obj.Type())
+	}
+
+	val := obj.Val()
+	var capType, text string
+	switch basic.Kind() {
+	case types.Bool, types.UntypedBool:
+		capType, text = "Bool", strconv.FormatBool(constant.BoolVal(val))
+	case types.String, types.UntypedString:
+		capType, text = "Text", constant.StringVal(val)
+	case types.Float32:
+		f, _ := constant.Float32Val(val)
+		capType, text = "Float32", strconv.FormatFloat(float64(f), 'g', -1, 32)
+	case types.Float64, types.UntypedFloat:
+		f, _ := constant.Float64Val(val)
+		capType, text = "Float64", strconv.FormatFloat(f, 'g', -1, 64)
+	case types.UntypedInt, types.UntypedRune:
+		capType, text = "Int64", val.ExactString()
+		if _, exact := constant.Int64Val(val); !exact {
+			capType = "UInt64"
+		}
+		if basic.Kind() == types.UntypedRune {
+			capType = "Int32"
+		}
+	default:
+		if basic.Info()&types.IsInteger == 0 {
+			return nil, fmt.Errorf("its type %s has no capnp equivalent", obj.Type())
+		}
+		capType, text = x.g2c(basic.Name()), val.ExactString()
+	}
+
+	lit, err := capnpDefaultLiteral(capType, text)
+	if err != nil {
+		return nil, err
+	}
+	return &GoConst{goName: ident.Name, capName: capName, capType: capType, value: lit}, nil
+}
+
+// WriteConstSchema writes the recorded constants as capnp consts, by name.
+func (x *Extractor) WriteConstSchema(w io.Writer) (n int64, err error) {
+	names := make([]string, 0, len(x.consts))
+	for name := range x.consts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := x.consts[name]
+		m, err := fmt.Fprintf(w, "%sconst %s: %s = %s; %s", x.fieldSuffix, c.capName, c.capType, c.value, x.fieldSuffix)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
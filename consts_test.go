@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+const constsSrc = `
+// bambam:const
+const MaxBatch = 512
+
+const (
+	// bambam:const
+	ProtocolVersion = "v3"
+	notExported     = 7
+	Unmarked        = 1
+)
+
+type Color uint8
+
+// bambam:const
+const (
+	Red Color = iota + 1
+	Green
+	Ratio float32 = 1.5
+	Big = 1 << 63
+	Debug = MaxBatch > 100
+)
+
+type S struct { A int }
+`
+
+func TestConstsToSchema(t *testing.T) {
+
+	cv.Convey("Given Go constants marked // bambam:const", t, func() {
+		cv.Convey("then the schema should declare them as typed capnp consts, with their evaluated values", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+constsSrc, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `
+const big: UInt64 = 9223372036854775808;
+const debug: Bool = true;
+const green: UInt8 = 2;
+const maxBatch: Int64 = 512;
+const protocolVersion: Text = "v3";
+const ratio: Float32 = 1.5;
+const red: UInt8 = 1;`)
+			cv.So(strings.Contains(schema.String(), "unmarked"), cv.ShouldEqual, false)
+			cv.So(len(ValidateCapnpFile("consts", schema.Bytes())), cv.ShouldEqual, 0)
+		})
+
+		cv.Convey("then under -consts every exported constant should go into the schema", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			x.allConsts = true
+			_, err := ExtractStructs("", "package main; "+constsSrc, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(x.EvalConsts(), cv.ShouldEqual, nil)
+			cv.So(x.consts["unmarked"] != nil, cv.ShouldEqual, true)
+			cv.So(x.consts["notExported"] == nil, cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given marked constants that use another input file's declarations, or an import's", t, func() {
+		cv.Convey("then the files should be type-checked together, imports from source", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := x.ExtractStructsFromOneFile(`package main
+import "time"
+
+// bambam:const
+const (
+	Limit   = OtherFileLimit * 2
+	Timeout = 5 * time.Second
+)
+
+// bambam:const
+const (
+	Low Level = iota
+	High
+)
+`, "")
+			cv.So(err, cv.ShouldEqual, nil)
+			_, err = x.ExtractStructsFromOneFile("package main\n\nconst OtherFileLimit = 10\n\ntype Level uint16\n", "")
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `
+const high: UInt16 = 1;
+const limit: Int64 = 20;
+const low: UInt16 = 0;
+const timeout: Int64 = 5000000000;`)
+		})
+	})
+
+	cv.Convey("Given a marked constant whose value can't be worked out", t, func() {
+		cv.Convey("then writing the schema should fail, naming the constant", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main\n// bambam:const\nconst Limit = NowhereLimit * 2\n", x)
+			cv.So(err, cv.ShouldEqual, nil)
+			_, err = x.WriteToSchema(&bytes.Buffer{})
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "const 'Limit': can't work out its value"), cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given -consts and exported constants with no capnp equivalent", t, func() {
+		cv.Convey("then those should be skipped, and the rest written", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			x.allConsts = true
+			_, err := ExtractStructs("", "package main\nconst Ok = 3\nconst Cplx = 1 + 2i\nconst Unknown = Nowhere\n", x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, "const ok: Int64 = 3;")
+			cv.So(strings.Contains(schema.String(), "cplx"), cv.ShouldEqual, false)
+			cv.So(strings.Contains(schema.String(), "unknown"), cv.ShouldEqual, false)
+		})
+	})
+}
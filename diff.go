@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 func Diffb(a string, b string) []byte {
@@ -43,3 +45,145 @@ func SimpleTempFile(dirpath string) *os.File {
 	}
 	return f
 }
+
+// UnifiedDiff returns the unified diff, with 3 lines of context, that
+// turns a into b, labelled aName and bName; it is empty if they are
+// the same. When a and b have as many lines, which is the case when
+// only capid tags were added, changes are found line by line; if not,
+// everything from the first to the last differing line is one change.
+func UnifiedDiff(aName, bName string, a, b []byte) string {
+	al, bl := diffLines(a), diffLines(b)
+
+	// the edit script: ' ' keeps a line, '-' drops one of a, '+' adds one of b.
+	type op struct {
+		kind byte
+		text string
+	}
+	var ops []op
+	if len(al) == len(bl) {
+		for i := 0; i < len(al); {
+			if al[i] == bl[i] {
+				ops = append(ops, op{' ', al[i]})
+				i++
+				continue
+			}
+			// a run of changed lines: all of a's, then all of b's.
+			j := i
+			for j < len(al) && al[j] != bl[j] {
+				j++
+			}
+			for _, l := range al[i:j] {
+				ops = append(ops, op{'-', l})
+			}
+			for _, l := range bl[i:j] {
+				ops = append(ops, op{'+', l})
+			}
+			i = j
+		}
+	} else {
+		pre := 0
+		for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+			pre++
+		}
+		suf := 0
+		for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+			suf++
+		}
+		for _, l := range al[:pre] {
+			ops = append(ops, op{' ', l})
+		}
+		for _, l := range al[pre : len(al)-suf] {
+			ops = append(ops, op{'-', l})
+		}
+		for _, l := range bl[pre : len(bl)-suf] {
+			ops = append(ops, op{'+', l})
+		}
+		for _, l := range al[len(al)-suf:] {
+			ops = append(ops, op{' ', l})
+		}
+	}
+
+	const context = 3
+	var buf bytes.Buffer
+	aLine, bLine := 1, 1 // numbers of the next a and b lines
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// a hunk: back up over the context before the change, then run
+		// on until more than 2*context unchanged lines follow a change.
+		start := i
+		for start > 0 && i-start < context && ops[start-1].kind == ' ' {
+			start--
+		}
+		end, same := i, 0
+		for end < len(ops) && same <= 2*context {
+			if ops[end].kind == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+			end++
+		}
+		if same > context {
+			end -= same - context
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aLen, bLen := 0, 0
+		var hunk bytes.Buffer
+		for _, o := range ops[start:end] {
+			if o.kind != '+' {
+				aLen++
+			}
+			if o.kind != '-' {
+				bLen++
+			}
+			hunk.WriteByte(o.kind)
+			hunk.WriteString(o.text)
+			if !strings.HasSuffix(o.text, "\n") {
+				hunk.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		buf.Write(hunk.Bytes())
+
+		for _, o := range ops[i:end] {
+			if o.kind != '+' {
+				aLine++
+			}
+			if o.kind != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+// diffLines splits text into lines, each keeping its "\n".
+func diffLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunkRange formats a hunk's start and length as in "@@ -3,7 +3,7 @@".
+// An empty range starts at the line before it.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		start--
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
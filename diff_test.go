@@ -35,3 +35,26 @@ type s1 struct {
 		})
 	})
 }
+
+func TestUnifiedDiff(t *testing.T) {
+
+	cv.Convey("Given two texts with the same number of lines", t, func() {
+		cv.Convey("then UnifiedDiff should give one hunk per group of changed lines, with 3 lines of context", func() {
+			a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+			b := "1\n2x\n3\n4\n5\n6\n7\n8\n9\n10\n11x\n12\n"
+			cv.So(UnifiedDiff("a.go", "b.go", []byte(a), []byte(b)), cv.ShouldEqual, "--- a.go\n+++ b.go\n"+
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+2x\n 3\n 4\n 5\n"+
+				"@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+11x\n 12\n")
+			cv.So(UnifiedDiff("a.go", "a.go", []byte(a), []byte(a)), cv.ShouldEqual, "")
+			cv.So(UnifiedDiff("a", "b", []byte("1\n2\n3\n"), []byte("1x\n2x\n3\n")), cv.ShouldEqual, "--- a\n+++ b\n"+
+				"@@ -1,3 +1,3 @@\n-1\n-2\n+1x\n+2x\n 3\n")
+		})
+	})
+
+	cv.Convey("Given texts with different numbers of lines, and no final newline", t, func() {
+		cv.Convey("then UnifiedDiff should give the changed middle as one hunk", func() {
+			cv.So(UnifiedDiff("a", "b", []byte("x\ny"), []byte("x\nn\ny")), cv.ShouldEqual, "--- a\n+++ b\n"+
+				"@@ -1,2 +1,3 @@\n x\n+n\n y\n\\ No newline at end of file\n")
+		})
+	})
+}
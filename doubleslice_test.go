@@ -21,21 +21,25 @@ struct MatrixCapn {
 } 
 
   func (s *Matrix) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	MatrixGoToCapn(seg, s)
-    	_, err := seg.WriteTo(w)
-        return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *Matrix) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	MatrixGoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
    
   func (s *Matrix) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
-    	}
-    	z := ReadRootMatrixCapn(capMsg)
-        MatrixCapnToGo(z, s)
-        return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootMatrixCapn(capMsg)
+  	MatrixCapnToGo(z, s)
+  	return nil
   }
   
   func MatrixCapnToGo(src MatrixCapn, dest *Matrix) *Matrix { 
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"io"
+)
+
+// WriteToEncoder writes the CapnSaver interface and the arena-reusing
+// CapnEncoder, once per generated package; see templates/encoder.tmpl.
+func (x *Extractor) WriteToEncoder(w io.Writer) (n int64, err error) {
+	m, err := w.Write(x.render("encoder", &FileData{PkgName: x.pkgName}))
+	return int64(m), err
+}
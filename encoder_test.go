@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test018EncoderAllocatesLessThanSave(t *testing.T) {
+
+	tdir := NewTempDir()
+	// comment the defer out to debug any encoder test failures.
+	defer tdir.Cleanup()
+
+	err := exec.Command("cp", "encoder.go.txt", tdir.DirPath+"/encoder.go").Run()
+	if err != nil {
+		panic(err)
+	}
+	err = exec.Command("cp", "encoder_bench_test.go.txt", tdir.DirPath+"/encoder_bench_test.go").Run()
+	if err != nil {
+		panic(err)
+	}
+
+	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, "encoder.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
+
+	cv.Convey("Given bambam generated go bindings with SaveWith and an Encoder", t, func() {
+		cv.Convey("then encoding through a reused Encoder should allocate fewer bytes per message than Save", func() {
+
+			tdir.MoveTo()
+
+			err = exec.Command("capnpc", "-ogo", "schema.capnp").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			out, err := exec.Command("go", "test", "-run", "NONE", "-bench", ".", "-benchmem").CombinedOutput()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			save := benchBytesPerOp(string(out), "BenchmarkSave")
+			enc := benchBytesPerOp(string(out), "BenchmarkEncoderEncode")
+			cv.So(save > 0, cv.ShouldEqual, true)
+			cv.So(enc < save, cv.ShouldEqual, true)
+		})
+	})
+}
+
+// encoderFixture holds encoder.go.txt's Payload, with its translators
+// and capnpc-go bindings checked in, so that its allocation test runs
+// without the capnp tools.
+const encoderFixture = "testdata/encoderalloc"
+
+func Test023EncoderFixtureAllocatesLessThanSave(t *testing.T) {
+
+	tdir := NewTempDir()
+	defer tdir.Cleanup()
+
+	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, encoderFixture + "/encoder.go"})
+
+	cv.Convey("Given the checked-in encoder fixture", t, func() {
+		cv.Convey("then its translateCapn.go should be what bambam generates now", func() {
+			now, err := ioutil.ReadFile(tdir.DirPath + "/translateCapn.go")
+			cv.So(err, cv.ShouldEqual, nil)
+			fixture, err := ioutil.ReadFile(encoderFixture + "/translateCapn.go")
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(fixture), cv.ShouldEqual, string(now))
+		})
+	})
+
+	// the fixture builds against go-capnproto, which the go tool fetches.
+	if out, err := exec.Command("go", "-C", encoderFixture, "mod", "download").CombinedOutput(); err != nil {
+		t.Skipf("skipping the encoder fixture's allocation test: can't fetch its modules: %s", out)
+	}
+
+	cv.Convey("Given the checked-in encoder fixture, built against go-capnproto", t, func() {
+		cv.Convey("then encoding through a reused CapnEncoder should allocate less per message than Save", func() {
+			out, err := exec.Command("go", "-C", encoderFixture, "test", "-count=1", "-v", ".").CombinedOutput()
+			cv.So(string(out), ShouldContainModuloWhiteSpace, "--- PASS: TestEncoderAllocatesLessThanSave")
+			cv.So(err, cv.ShouldEqual, nil)
+		})
+	})
+}
+
+// benchBytesPerOp pulls the B/op figure for benchmark name out of go test -benchmem output.
+func benchBytesPerOp(out string, name string) int {
+	re := regexp.MustCompile(name + `\S*\s+\d+\s+\S+ ns/op\s+(\d+) B/op`)
+	match := re.FindStringSubmatch(out)
+	if match == nil {
+		return -1
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
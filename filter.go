@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// regexIgnore matches the // bambam:ignore directive in a struct's doc comment.
+var regexIgnore = regexp.MustCompile(`bambam:ignore\b`)
+
+// regexBambamDirective matches a comment line holding a bambam:
+// directive, like bambam:ignore, which the schema comments leave out.
+var regexBambamDirective = regexp.MustCompile(`^bambam[ \t]*:`)
+
+// StructPattern matches struct names for -include and -exclude. A
+// pattern written between slashes, like /^Msg[0-9]+$/, is a regular
+// expression; anything else is a glob, as in path.Match.
+type StructPattern struct {
+	Text string
+	re   *regexp.Regexp
+}
+
+func NewStructPattern(s string) (*StructPattern, error) {
+	p := &StructPattern{Text: s}
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("bad struct pattern '%s': %s", s, err)
+		}
+		p.re = re
+		return p, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return nil, fmt.Errorf("bad struct pattern '%s': %s", s, err)
+	}
+	return p, nil
+}
+
+func (p *StructPattern) Match(goName string) bool {
+	if p.re != nil {
+		return p.re.MatchString(goName)
+	}
+	ok, _ := path.Match(p.Text, goName)
+	return ok
+}
+
+// StructPatternList is a flag.Value collecting patterns from repeated
+// and comma separated flags: -include 'Msg*' -include 'Req*,Resp*'.
+type StructPatternList []*StructPattern
+
+func (ps *StructPatternList) String() string {
+	var s []string
+	for _, p := range *ps {
+		s = append(s, p.Text)
+	}
+	return strings.Join(s, ",")
+}
+
+func (ps *StructPatternList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s == "" {
+			continue
+		}
+		p, err := NewStructPattern(s)
+		if err != nil {
+			return err
+		}
+		*ps = append(*ps, p)
+	}
+	return nil
+}
+
+func (ps StructPatternList) Match(goName string) bool {
+	for _, p := range ps {
+		if p.Match(goName) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectStructs narrows x.srs down to the structs that should be
+// serialized. The roots are the structs matching x.include (all of
+// them, if x.include is empty), minus those matching x.exclude. Then
+// every struct that a root refers to, directly or not, is kept too,
+// since the translators can't work without it. A kept struct that
+// refers to an excluded or // bambam:ignore'd struct is an error.
+func (x *Extractor) SelectStructs() error {
+
+	keep := make(map[string]bool)
+	var queue []string
+	for goName := range x.srs {
+		if len(x.include) > 0 && !x.include.Match(goName) {
+			continue
+		}
+		if x.exclude.Match(goName) {
+			continue
+		}
+		keep[goName] = true
+		queue = append(queue, goName)
+	}
+	// stable error messages
+	sort.Strings(queue)
+
+	for len(queue) > 0 {
+		s := x.srs[queue[0]]
+		queue = queue[1:]
+		for _, f := range s.fld {
+			ref := last(f.goTypeSeq)
+			if x.ignored[ref] {
+				return fmt.Errorf("struct '%s' field '%s' refers to struct '%s', which is marked // bambam:ignore; tag the field capid:\"skip\" or drop the directive", s.goName, f.goName, ref)
+			}
+			if x.srs[ref] == nil || keep[ref] {
+				continue
+			}
+			if x.exclude.Match(ref) {
+				return fmt.Errorf("struct '%s' field '%s' refers to struct '%s', which -exclude leaves out; tag the field capid:\"skip\" or stop excluding '%s'", s.goName, f.goName, ref, ref)
+			}
+			keep[ref] = true
+			queue = append(queue, ref)
+		}
+	}
+
+	for goName := range x.srs {
+		if !keep[goName] {
+			VPrintf("SelectStructs: leaving out struct '%s'\n", goName)
+			delete(x.srs, goName)
+		}
+	}
+
+	// the list helpers were made as fields were seen; keep only
+	// those that the remaining structs use.
+	x.listHelpers = make(map[string]*ListHelper)
+	for _, s := range x.srs {
+		for _, f := range s.fld {
+			x.GoTypeToCapnpType(f, f.goTypeSeq)
+		}
+	}
+	return nil
+}
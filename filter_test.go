@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// selectedStructs extracts src, applies -include/-exclude, and returns
+// the names of the structs left, sorted.
+func selectedStructs(src string, include string, exclude string) ([]string, error) {
+	x := NewExtractor()
+	defer x.Cleanup()
+	if err := x.include.Set(include); err != nil {
+		return nil, err
+	}
+	if err := x.exclude.Set(exclude); err != nil {
+		return nil, err
+	}
+	_, err := ExtractStructs("", "package main; "+src, x)
+	if err != nil {
+		return nil, err
+	}
+	err = x.SelectStructs()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for goName := range x.srs {
+		names = append(names, goName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+const filterSrc = `
+type MsgHello struct { From Peer; Hops []Route }
+type MsgBye struct { Reason string }
+type Peer struct { Addr string }
+type Route struct { Via Peer }
+type Config struct { Debug bool }
+type cacheInternal struct { N int }
+`
+
+func TestIncludeExcludeStructs(t *testing.T) {
+
+	cv.Convey("Given -include 'Msg*'", t, func() {
+		cv.Convey("then the Msg structs, and the structs they refer to directly or not, should be kept, and nothing else", func() {
+			names, err := selectedStructs(filterSrc, "Msg*", "")
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(strings.Join(names, " "), cv.ShouldEqual, "MsgBye MsgHello Peer Route")
+		})
+	})
+
+	cv.Convey("Given -exclude '*Internal,Config' and a regexp -exclude", t, func() {
+		cv.Convey("then those structs should be left out", func() {
+			names, err := selectedStructs(filterSrc, "", "*Internal,Config,/^MsgB/")
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(strings.Join(names, " "), cv.ShouldEqual, "MsgHello Peer Route")
+		})
+	})
+
+	cv.Convey("Given a kept struct that refers to an excluded one", t, func() {
+		cv.Convey("then SelectStructs should say which field is the problem", func() {
+			_, err := selectedStructs(filterSrc, "MsgHello", "Peer")
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(strings.HasPrefix(err.Error(), "struct 'MsgHello' field 'From' refers to struct 'Peer', which -exclude leaves out"), cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given a bad regexp", t, func() {
+		cv.Convey("then setting the pattern should fail", func() {
+			_, err := selectedStructs(filterSrc, "/(/", "")
+			cv.So(err != nil, cv.ShouldEqual, true)
+		})
+	})
+}
+
+func TestBambamIgnoreDirective(t *testing.T) {
+
+	cv.Convey("Given a struct marked // bambam:ignore", t, func() {
+		cv.Convey("then it should get no schema entry and no translators", func() {
+			ex0 := `
+// bambam:ignore
+type Scratch struct { M map[string]int }
+
+type Keep struct { A int }
+`
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+ex0, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(x.SelectStructs(), cv.ShouldEqual, nil)
+
+			var buf bytes.Buffer
+			x.WriteToSchema(&buf)
+			cv.So(buf.String(), ShouldMatchModuloWhiteSpace, `struct KeepCapn { a @0: Int64; }`)
+		})
+
+		cv.Convey("then a struct still referring to it should be an error", func() {
+			_, err := selectedStructs("\n// bambam:ignore\ntype Scratch struct { N int }\ntype Keep struct { S Scratch }", "", "")
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(strings.HasPrefix(err.Error(), "struct 'Keep' field 'S' refers to struct 'Scratch', which is marked // bambam:ignore"), cv.ShouldEqual, true)
+		})
+	})
+}
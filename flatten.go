@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// promotedPtr is an embedded pointer that a flattened field is
+// reached through: dest.Path, of type *GoType.
+type promotedPtr struct {
+	Path   string
+	GoType string
+}
+
+// FlattenEmbedded inlines the fields of embedded structs marked for
+// flattening (capid:"flatten", or every embed under -flatten) into the
+// structs that embed them. The inlined fields take the embed's place
+// in order of appearance, in the embedded struct's own field order, so
+// their ordinals are the same on every run. Fields with a capid number
+// in the parent keep it.
+//
+// The translators reach inlined fields through Go's promotion, e.g.
+// dest.X rather than dest.Embedded.X.
+func (x *Extractor) FlattenEmbedded() error {
+
+	// flatten in a stable order, for stable error messages.
+	names := make([]string, 0, len(x.srs))
+	for goName := range x.srs {
+		names = append(names, goName)
+	}
+	sort.Strings(names)
+
+	done := make(map[string]bool)
+	for _, goName := range names {
+		err := x.flattenStruct(x.srs[goName], done, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *Extractor) flattenStruct(s *Struct, done map[string]bool, path []string) error {
+	if done[s.goName] {
+		return nil
+	}
+	for _, p := range path {
+		if p == s.goName {
+			return fmt.Errorf("cannot flatten embedded structs that embed each other: %s -> %s", strings.Join(path, " -> "), s.goName)
+		}
+	}
+	path = append(path, s.goName)
+
+	appear := make([]*Field, len(s.fld))
+	copy(appear, s.fld)
+	sort.Sort(ByOrderOfAppearance(appear))
+
+	var fields []*Field
+	flattened := false
+	for _, f := range appear {
+		if !f.flatten {
+			fields = append(fields, f)
+			continue
+		}
+		emb := x.srs[f.goType]
+		if emb == nil {
+			if f.flattenByFlag {
+				// not one of our structs; keep it as a field.
+				fields = append(fields, f)
+				continue
+			}
+			return fmt.Errorf(`struct '%s' embeds '%s' with capid:"flatten", but '%s' is not a struct bambam knows`, s.goName, f.goType, f.goType)
+		}
+		err := x.flattenStruct(emb, done, path)
+		if err != nil {
+			return err
+		}
+		flattened = true
+
+		emb.computeFinalOrder()
+		inner := make([]*Field, len(emb.fld))
+		copy(inner, emb.fld)
+		sort.Sort(ByFinalOrder(inner))
+
+		isPtr := isPointerType(f.goTypePrefix)
+		for _, g := range inner {
+			if g.union != "" {
+				return fmt.Errorf(`struct '%s' flattens '%s', whose field '%s' is in capunion '%s'; a union can't be flattened, so embed '%s' without flattening it`, s.goName, emb.goName, g.goName, g.union, emb.goName)
+			}
+			c := *g
+			c.flattenedFrom = emb.goName
+			c.capIdFromTag = 0
+			c.promoted = nil
+			if isPtr {
+				c.promoted = append(c.promoted, promotedPtr{Path: f.goName, GoType: f.goType})
+			}
+			for _, p := range g.promoted {
+				c.promoted = append(c.promoted, promotedPtr{Path: f.goName + "." + p.Path, GoType: p.GoType})
+			}
+			fields = append(fields, &c)
+		}
+	}
+
+	if flattened {
+		seenGo := make(map[string]*Field)
+		seenCap := make(map[string]*Field)
+		for i, f := range fields {
+			if g := seenGo[f.goName]; g != nil {
+				return fmt.Errorf("flattening into struct '%s': field '%s' %s collides with field '%s' %s; rename one, or don't flatten", s.goName, f.goName, flatOrigin(f), g.goName, flatOrigin(g))
+			}
+			if g := seenCap[f.capname]; g != nil {
+				return fmt.Errorf("flattening into struct '%s': capnp field name '%s' of '%s' %s collides with '%s' %s; use a capname tag on one", s.goName, f.capname, f.goName, flatOrigin(f), g.goName, flatOrigin(g))
+			}
+			seenGo[f.goName] = f
+			seenCap[f.capname] = f
+
+			f.orderOfAppearance = i
+			if len(f.capname) > s.longestField {
+				s.longestField = len(f.capname)
+			}
+		}
+		s.fld = fields
+	}
+
+	done[s.goName] = true
+	return nil
+}
+
+// flatOrigin says where a field came from, for error messages.
+func flatOrigin(f *Field) string {
+	if f.flattenedFrom != "" {
+		return "(from embedded " + f.flattenedFrom + ")"
+	}
+	return "(declared directly)"
+}
+
+// PromotedPtrs are the embedded pointers that the flattened fields of s
+// are reached through, outermost first; XCapnToGo allocates them.
+func (s *Struct) PromotedPtrs() []promotedPtr {
+	var ptrs []promotedPtr
+	seen := make(map[string]bool)
+	for _, f := range s.fld {
+		for _, p := range f.promoted {
+			if !seen[p.Path] {
+				seen[p.Path] = true
+				ptrs = append(ptrs, p)
+			}
+		}
+	}
+	return ptrs
+}
+
+// NilGuard is the condition under which src.GoName can be read, when
+// it is promoted through embedded pointers that might be nil; "" if
+// it can always be read.
+func (f *Field) NilGuard() string {
+	var conds []string
+	for _, p := range f.promoted {
+		conds = append(conds, "src."+p.Path+" != nil")
+	}
+	return strings.Join(conds, " && ")
+}
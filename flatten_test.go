@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// flattenExtract extracts src, flattens its embedded structs, and
+// generates schema and translators.
+func flattenExtract(src string, flag bool) (*Extractor, string, error) {
+	x := NewExtractor()
+	x.flattenEmbedded = flag
+	_, err := ExtractStructs("", "package main; "+src, x)
+	if err != nil {
+		x.Cleanup()
+		return nil, "", err
+	}
+	err = x.FlattenEmbedded()
+	if err != nil {
+		x.Cleanup()
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	_, err = x.WriteToSchema(&buf)
+	if err != nil {
+		panic(err)
+	}
+	x.GenerateTranslators()
+	return x, string(buf.Bytes()), nil
+}
+
+func TestFlattenEmbeddedStruct(t *testing.T) {
+
+	cv.Convey("Given a struct embedding another with capid:\"flatten\"", t, func() {
+		cv.Convey("then the embedded fields should take the embed's place, and the translators should reach them by promotion", func() {
+
+			ex0 := "type Base struct { ID int64; Name string }\n" +
+				"type Doc struct { Title string; Base `capid:\"flatten\"`; Body string }"
+			x, schema, err := flattenExtract(ex0, false)
+			cv.So(err, cv.ShouldEqual, nil)
+			defer x.Cleanup()
+			cv.So(schema, ShouldContainModuloWhiteSpace, `struct DocCapn { title @0: Text; iD @1: Int64; name @2: Text; body @3: Text; } `)
+			cv.So(string(x.ToGoCodeFor("Doc")), ShouldContainModuloWhiteSpace, `dest.Title = src.Title() dest.ID = src.ID() dest.Name = src.Name() dest.Body = src.Body()`)
+			cv.So(string(x.ToCapnCodeFor("Doc")), ShouldContainModuloWhiteSpace, `dest.SetID(src.ID)`)
+		})
+
+		cv.Convey("then a capid number in the parent should still pin its field", func() {
+
+			ex0 := "type Base struct { ID int64; Name string }\n" +
+				"type Doc struct { Title string `capid:\"3\"`; Base `capid:\"flatten\"`; Body string }"
+			x, schema, err := flattenExtract(ex0, false)
+			cv.So(err, cv.ShouldEqual, nil)
+			defer x.Cleanup()
+			cv.So(schema, ShouldContainModuloWhiteSpace, `struct DocCapn { iD @0: Int64; name @1: Text; body @2: Text; title @3: Text; } `)
+		})
+	})
+
+	cv.Convey("Given -flatten and embedded pointers, two deep", t, func() {
+		cv.Convey("then every embed should be inlined, and the translators should allocate the pointers on load and skip nil ones on save", func() {
+
+			ex0 := "type Base struct { ID int64 }\n" +
+				"type Meta struct { Tags []string; *Base }\n" +
+				"type Doc struct { *Meta; Size int }"
+			x, schema, err := flattenExtract(ex0, true)
+			cv.So(err, cv.ShouldEqual, nil)
+			defer x.Cleanup()
+			cv.So(schema, ShouldContainModuloWhiteSpace, `struct DocCapn { tags @0: List(Text); iD @1: Int64; size @2: Int64; } `)
+			cv.So(string(x.ToGoCodeFor("Doc")), ShouldContainModuloWhiteSpace, `if dest.Meta == nil { dest.Meta = new(Meta) } if dest.Meta.Base == nil { dest.Meta.Base = new(Base) }`)
+			cv.So(string(x.ToCapnCodeFor("Doc")), ShouldContainModuloWhiteSpace, `if src.Meta != nil && src.Meta.Base != nil { dest.SetID(src.ID) }`)
+		})
+
+		cv.Convey("then an embed with a capid number should keep its own field", func() {
+
+			ex0 := "type Base struct { ID int64 }\n" +
+				"type Doc struct { Base `capid:\"1\"`; Size int }"
+			x, schema, err := flattenExtract(ex0, true)
+			cv.So(err, cv.ShouldEqual, nil)
+			defer x.Cleanup()
+			cv.So(schema, ShouldContainModuloWhiteSpace, `struct DocCapn { size @0: Int64; base @1: BaseCapn; } `)
+		})
+	})
+
+	cv.Convey("Given a flattened field whose name collides with the parent's", t, func() {
+		cv.Convey("then FlattenEmbedded should report both fields", func() {
+
+			ex0 := "type Base struct { ID int64 }\n" +
+				"type Doc struct { ID string; Base `capid:\"flatten\"` }"
+			_, _, err := flattenExtract(ex0, false)
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(strings.HasPrefix(err.Error(), "flattening into struct 'Doc': field 'ID' (from embedded Base) collides with field 'ID' (declared directly)"), cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given capid:\"flatten\" on a field that isn't embedded", t, func() {
+		cv.Convey("then extraction should fail", func() {
+
+			ex0 := "type Base struct { ID int64 }\n" +
+				"type Doc struct { B Base `capid:\"flatten\"` }"
+			_, _, err := flattenExtract(ex0, false)
+			cv.So(err != nil, cv.ShouldEqual, true)
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	gofmt "go/format"
+	"sort"
+)
+
+// FormatGenerated gofmts src, the complete generated Go file fn, so
+// output never needs a hand-run of gofmt. If src doesn't even parse,
+// that's a bug in bambam; we format each struct's generated code on
+// its own to find, and name, the struct responsible.
+func (x *Extractor) FormatGenerated(fn string, src []byte) ([]byte, error) {
+	formatted, err := gofmt.Source(src)
+	if err == nil {
+		return formatted, nil
+	}
+
+	// sort structs alphabetically to get a stable (testable) ordering.
+	sortedStructs := ByGoName(make([]*Struct, 0, len(x.srs)))
+	for _, strct := range x.srs {
+		sortedStructs = append(sortedStructs, strct)
+	}
+	sort.Sort(ByGoName(sortedStructs))
+
+	for _, s := range sortedStructs {
+		var chunk bytes.Buffer
+		chunk.Write(x.SaveCode[s.goName])
+		chunk.Write(x.LoadCode[s.goName])
+		chunk.Write(x.ToGoCode[s.goName])
+		chunk.Write(x.ToCapnCode[s.goName])
+		chunk.Write(x.ViewCode[s.goName])
+
+		_, perr := gofmt.Source(chunk.Bytes())
+		if perr != nil {
+			return nil, fmt.Errorf("bambam bug: the Go generated for struct '%s' in '%s' does not parse: %s\n%s", s.goName, fn, perr, string(chunk.Bytes()))
+		}
+	}
+	return nil, fmt.Errorf("bambam bug: generated '%s' does not parse: %s\n%s", fn, err, string(src))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	gofmt "go/format"
+	"os"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestGeneratedTranslatorsAreGofmtClean(t *testing.T) {
+
+	cv.Convey("Given bambam translators, views and encoder for structs with lists, nested structs and complex numbers", t, func() {
+		cv.Convey("then the raw template output should already be what gofmt makes of it", func() {
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; type Inner struct { C int }; type Outer struct { A []int; B Inner; M [][]Inner; Z complex128 }", x)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			var buf bytes.Buffer
+			buf.Write(x.render("header", &FileData{PkgName: x.pkgName}))
+			x.WriteToTranslators(&buf)
+			x.WriteToViews(&buf)
+			x.WriteToEncoder(&buf)
+
+			formatted, err := gofmt.Source(buf.Bytes())
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(buf.String(), cv.ShouldEqual, string(formatted))
+		})
+	})
+}
+
+func TestUnparsableGeneratedCodeNamesTheStruct(t *testing.T) {
+
+	cv.Convey("Given generated code for struct Outer that does not parse", t, func() {
+		cv.Convey("then FormatGenerated should refuse it, and report a bambam bug naming Outer", func() {
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; type Inner struct { C int }; type Outer struct { A int }", x)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			var buf bytes.Buffer
+			buf.WriteString("package main\n")
+			x.WriteToTranslators(&buf)
+			// simulate a broken template
+			x.ToCapnCode["Outer"] = []byte("\nfunc OuterGoToCapn(seg *capn.Segment, src *Outer) OuterCapn {\n")
+			buf.Write(x.ToCapnCode["Outer"])
+
+			formatted, err := x.FormatGenerated("translateCapn.go", buf.Bytes())
+			cv.So(formatted == nil, cv.ShouldEqual, true)
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(strings.HasPrefix(err.Error(), "bambam bug: the Go generated for struct 'Outer'"), cv.ShouldEqual, true)
+
+			fn := x.compileDir.DirPath + "/translateCapn.go"
+			err = x.writeFormatted(fn, buf.Bytes())
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(strings.Contains(err.Error(), "struct 'Outer'"), cv.ShouldEqual, true)
+			_, statErr := os.Stat(fn)
+			cv.So(os.IsNotExist(statErr), cv.ShouldEqual, true)
+		})
+	})
+}
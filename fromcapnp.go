@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	gofmt "go/format"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GoFromCapnpFile reads the .capnp schema in fn and returns the name
+// and source of a Go file declaring one struct per capnp struct, with
+// capid tags matching the schema's ordinals. Handing that file to an
+// Extractor produces translators for it, as for any other Go input.
+// See GoFromCapnp for lossy.
+func GoFromCapnpFile(fn string, pkgName string, lossy bool) (goFn string, src []byte, warnings []string, err error) {
+	text, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	schema, err := ParseCapnpSchema(fn, text)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	src, warnings, err = GoFromCapnp(schema, pkgName, lossy)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	goFn = strings.TrimSuffix(filepath.Base(fn), ".capnp") + ".go"
+	return goFn, src, warnings, nil
+}
+
+// GoFromCapnp writes Go struct definitions for schema. Nested types
+// are named by joining their path, so Outer.Inner becomes OuterInner.
+// Enums become a uint16 type with a const per enumerant, and enum
+// fields have that type; a list of enums is a []uint16. Members of
+// groups are flattened into the enclosing struct, prefixed with the
+// group name, which keeps their place in the struct's layout.
+//
+// A named union whose members are all structs becomes *T fields with
+// a capunion tag. Some parts of a schema the Go structs can't carry
+// exactly: other unions, whose members would become ordinary fields,
+// Void fields, which would become bools, and default values other
+// than literals. The schema bambam writes for such structs is not
+// wire-compatible with the original, so GoFromCapnp refuses them,
+// unless lossy is set; then it converts them anyway, and lists each
+// in warnings.
+func GoFromCapnp(schema *CapnpSchema, pkgName string, lossy bool) (src []byte, warnings []string, err error) {
+
+	r := &capnpResolver{
+		schema:  schema,
+		structs: make(map[string]*CapnpStruct),
+		enums:   make(map[string]*CapnpEnum),
+	}
+	for _, s := range schema.AllStructs() {
+		r.structs[s.QualifiedName()] = s
+	}
+	for _, e := range schema.AllEnums() {
+		r.enums[e.QualifiedName()] = e
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by bambam -from-capnp %s; DO NOT EDIT.\n\npackage %s\n", filepath.Base(schema.Filename), pkgName)
+
+	for _, e := range schema.AllEnums() {
+		goName := capnpGoName(e.QualifiedName())
+		fmt.Fprintf(&buf, "\n// %s is enum %s in %s.\ntype %s uint16\n\nconst (\n", goName, e.QualifiedName(), filepath.Base(schema.Filename), goName)
+		for _, en := range e.Enumerants {
+			fmt.Fprintf(&buf, "\t%s%s %s = %d\n", goName, UppercaseFirstLetter(en.Name), goName, en.Ordinal)
+		}
+		fmt.Fprintf(&buf, ")\n")
+	}
+
+	for _, s := range schema.AllStructs() {
+		w, err := r.writeStruct(&buf, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, w...)
+	}
+	if len(warnings) > 0 && !lossy {
+		return nil, nil, fmt.Errorf("the Go structs for '%s' would not be wire-compatible with it:\n  %s\n(-from-capnp-lossy converts it anyway)", schema.Filename, strings.Join(warnings, "\n  "))
+	}
+
+	src, err = gofmt.Source(buf.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("bambam bug: the Go generated from '%s' does not parse: %s", schema.Filename, err)
+	}
+	return src, warnings, nil
+}
+
+type capnpResolver struct {
+	schema  *CapnpSchema
+	structs map[string]*CapnpStruct // by qualified name
+	enums   map[string]*CapnpEnum
+}
+
+// capnpGoName turns the qualified capnp name Outer.Inner into OuterInner.
+func capnpGoName(qualified string) string {
+	parts := strings.Split(qualified, ".")
+	for i := range parts {
+		parts[i] = UppercaseFirstLetter(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+func (r *capnpResolver) writeStruct(buf *bytes.Buffer, s *CapnpStruct) (warnings []string, err error) {
+
+	where := fmt.Sprintf("%s:%d", r.schema.Filename, s.Line)
+
+	fields := append([]*CapnpField{}, s.Fields...)
+	sort.Sort(capnpFieldsByOrdinal(fields))
+	for i, f := range fields {
+		if f.Ordinal != i {
+			return nil, fmt.Errorf("%s: struct %s: ordinals must run 0..%d without gaps, but field '%s' is @%d", where, s.Name, len(fields)-1, f.Name, f.Ordinal)
+		}
+	}
+
+	for _, u := range s.Unions {
+		if r.isStructUnion(s, u) {
+			continue
+		}
+		name := "unnamed union"
+		if u.Name != "" {
+			name = "union " + u.Name
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: struct %s: the members of its %s become ordinary fields, and the union's tag is lost.", where, s.Name, name))
+	}
+
+	fmt.Fprintf(buf, "\n// %s is struct %s in %s.\ntype %s struct {\n", capnpGoName(s.QualifiedName()), s.QualifiedName(), filepath.Base(r.schema.Filename), capnpGoName(s.QualifiedName()))
+	for _, f := range fields {
+		goType, err := r.goType(s, f.Type, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: field %s.%s: %s", r.schema.Filename, f.Line, s.Name, f.Name, err)
+		}
+
+		goName := UppercaseFirstLetter(f.Name)
+		for g := f.Group; g != nil; g = g.Parent {
+			if g.Name != "" {
+				goName = UppercaseFirstLetter(g.Name) + goName
+			}
+		}
+
+		tag := fmt.Sprintf(`capid:"%d"`, f.Ordinal)
+		if isCapnpKeyword(LowercaseCapnpFieldName(goName)) {
+			tag += fmt.Sprintf(` capname:"%sField"`, LowercaseCapnpFieldName(goName))
+		}
+
+		var notes []string
+		if f.Type.Name == "Void" {
+			notes = append(notes, "Void; bambam has no Void, so a bool holds its place")
+			warnings = append(warnings, fmt.Sprintf("%s:%d: field %s.%s is Void, which bambam writes as a Bool, taking a bit that Void doesn't.", r.schema.Filename, f.Line, s.Name, f.Name))
+		}
+		if enum := r.enumFor(s, f.Type); enum != nil && f.Type.Name == "List" {
+			notes = append(notes, "enum "+enum.QualifiedName())
+		}
+		if f.Group != nil && f.Group.IsUnion {
+			if r.isStructUnion(s, f.Group) {
+				goType = "*" + goType
+				tag += fmt.Sprintf(` capunion:"%s"`, f.Group.Name)
+			} else {
+				notes = append(notes, "union member")
+			}
+		}
+		if f.Default != "" {
+			if val, ok := capdefaultFor(f); ok {
+				tag += fmt.Sprintf(" capdefault:%s", strconv.Quote(val))
+			} else {
+				notes = append(notes, "default = "+f.Default)
+				warnings = append(warnings, fmt.Sprintf("%s:%d: field %s.%s: default value %s is not carried over, so a set value would be encoded differently.", r.schema.Filename, f.Line, s.Name, f.Name, f.Default))
+			}
+		}
+		comment := ""
+		if len(notes) > 0 {
+			comment = " // " + strings.Join(notes, "; ")
+		}
+		fmt.Fprintf(buf, "\t%s %s `%s`%s\n", goName, goType, tag, comment)
+	}
+	fmt.Fprintf(buf, "}\n")
+	return warnings, nil
+}
+
+// isStructUnion reports whether u is a named union, not inside another
+// group, whose two or more members are all structs: one that bambam
+// can write back as a capunion.
+func (r *capnpResolver) isStructUnion(s *CapnpStruct, u *CapnpGroup) bool {
+	if u.Name == "" || u.Parent != nil {
+		return false
+	}
+	members := 0
+	for _, f := range s.Fields {
+		if f.Group != u {
+			continue
+		}
+		if r.structFor(s, f.Type) == nil {
+			return false
+		}
+		members++
+	}
+	return members >= 2
+}
+
+// goType gives the Go type for capnp type t, used in struct s.
+// depth counts the enclosing Lists.
+func (r *capnpResolver) goType(s *CapnpStruct, t *CapnpType, depth int) (string, error) {
+	switch t.Name {
+	case "List":
+		if depth == 2 {
+			return "", fmt.Errorf("lists nest three deep; bambam handles at most [][]T")
+		}
+		elem, err := r.goType(s, t.Elem, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	case "Data":
+		if depth == 2 {
+			return "", fmt.Errorf("lists nest three deep; bambam handles at most [][]T")
+		}
+		return "[]byte", nil
+	case "Void", "Bool":
+		return "bool", nil
+	case "Text":
+		return "string", nil
+	case "AnyPointer", "AnyStruct", "AnyList", "Capability":
+		return "", fmt.Errorf("%s has no Go equivalent in bambam", t.Name)
+	}
+	if capnPrimitives[t.Name] {
+		// Int8 -> int8, UInt64 -> uint64, Float32 -> float32, ...
+		return strings.ToLower(t.Name), nil
+	}
+	if enum := r.enumFor(s, t); enum != nil {
+		if depth > 0 {
+			// bambam resolves type Kind uint16 only as a whole field type.
+			return "uint16", nil
+		}
+		return capnpGoName(enum.QualifiedName()), nil
+	}
+	if target := r.structFor(s, t); target != nil {
+		return capnpGoName(target.QualifiedName()), nil
+	}
+	return "", fmt.Errorf("unknown type '%s'", t.Name)
+}
+
+// lookupScoped finds name as seen from inside struct s: first among the
+// types nested in s, then in s's parents, then at the top level.
+func lookupScoped(s *CapnpStruct, name string, found func(qualified string) bool) string {
+	for scope := s; scope != nil; scope = scope.Parent {
+		q := scope.QualifiedName() + "." + name
+		if found(q) {
+			return q
+		}
+	}
+	if found(name) {
+		return name
+	}
+	return ""
+}
+
+func (r *capnpResolver) structFor(s *CapnpStruct, t *CapnpType) *CapnpStruct {
+	q := lookupScoped(s, t.Name, func(q string) bool { return r.structs[q] != nil })
+	return r.structs[q]
+}
+
+func (r *capnpResolver) enumFor(s *CapnpStruct, t *CapnpType) *CapnpEnum {
+	for t.Elem != nil {
+		t = t.Elem
+	}
+	q := lookupScoped(s, t.Name, func(q string) bool { return r.enums[q] != nil })
+	return r.enums[q]
+}
+
+type capnpFieldsByOrdinal []*CapnpField
+
+func (a capnpFieldsByOrdinal) Len() int           { return len(a) }
+func (a capnpFieldsByOrdinal) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a capnpFieldsByOrdinal) Less(i, j int) bool { return a[i].Ordinal < a[j].Ordinal }
+
+// capdefaultFor gives the capdefault tag value that carries over the
+// default of f, if f is a Bool, numeric or Text field with a literal
+// default, like 18 or "hi".
+func capdefaultFor(f *CapnpField) (string, bool) {
+	val := f.Default
+	if f.Type.Name == "Text" {
+		var err error
+		if val, err = strconv.Unquote(val); err != nil {
+			return "", false
+		}
+	}
+	if _, err := capnpDefaultLiteral(f.Type.Name, val); err != nil {
+		return "", false
+	}
+	return val, true
+}
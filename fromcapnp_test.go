@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+const theirSchema = `
+@0xd1b7c6b6bdbd6b3e;
+using Go = import "go.capnp";
+$Go.package("their");
+
+struct Person $Go.doc("someone") {
+  name @0 :Text;
+  age @1 :UInt8 = 18;
+  emails @2 :List(Text);
+  color @3 :Color;
+  home @4 :Address;
+  scores @5 :List(List(Float64));
+
+  enum Color {
+    red @0;
+    green @1;
+  }
+
+  struct Address {
+    street @0 :Text;
+    zip @1 :Int32;
+  }
+}
+
+struct Shape {
+  area @0 :Float64;
+  union {
+    circle @1 :Float64;   # radius
+    square @2 :Float64;
+  }
+  info :group {
+    label @3 :Text;
+    tags @4 :List(Person.Address);
+  }
+}
+
+interface Greeter {
+  greet @0 (name :Text) -> (reply :Text);
+}
+`
+
+func TestFromCapnpParsesSchema(t *testing.T) {
+
+	cv.Convey("Given a .capnp schema with nested types, an enum, a union and a group", t, func() {
+		cv.Convey("then ParseCapnpSchema should find every struct, nested or not, with its fields and ordinals", func() {
+
+			schema, err := ParseCapnpSchema("their.capnp", []byte(theirSchema))
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.ID, cv.ShouldEqual, "0xd1b7c6b6bdbd6b3e")
+
+			all := schema.AllStructs()
+			cv.So(len(all), cv.ShouldEqual, 3)
+			cv.So(all[1].QualifiedName(), cv.ShouldEqual, "Person.Address")
+
+			person := all[0]
+			cv.So(len(person.Fields), cv.ShouldEqual, 6)
+			cv.So(person.Fields[1].Default, cv.ShouldEqual, "18")
+			cv.So(person.Fields[5].Type.String(), cv.ShouldEqual, "List(List(Float64))")
+
+			shape := all[2]
+			cv.So(len(shape.Fields), cv.ShouldEqual, 5)
+			cv.So(shape.Fields[1].Group.IsUnion, cv.ShouldEqual, true)
+			cv.So(shape.Fields[3].Group.Name, cv.ShouldEqual, "info")
+		})
+	})
+}
+
+func TestFromCapnpWritesTaggedGoStructs(t *testing.T) {
+
+	cv.Convey("Given a .capnp schema", t, func() {
+		cv.Convey("then GoFromCapnp, allowed to be lossy, should write Go structs with capid tags matching the ordinals, flattening groups and nesting", func() {
+
+			schema, err := ParseCapnpSchema("their.capnp", []byte(theirSchema))
+			cv.So(err, cv.ShouldEqual, nil)
+			src, warnings, err := GoFromCapnp(schema, "their", true)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			cv.So(string(src), ShouldContainModuloWhiteSpace, `
+// PersonColor is enum Person.Color in their.capnp.
+type PersonColor uint16
+
+const (
+	PersonColorRed   PersonColor = 0
+	PersonColorGreen PersonColor = 1
+)`)
+
+			cv.So(string(src), ShouldContainModuloWhiteSpace, "type Person struct {\n"+
+				"Name string `capid:\"0\"`\n"+
+				"Age uint8 `capid:\"1\" capdefault:\"18\"`\n"+
+				"Emails []string `capid:\"2\"`\n"+
+				"Color PersonColor `capid:\"3\"`\n"+
+				"Home PersonAddress `capid:\"4\"`\n"+
+				"Scores [][]float64 `capid:\"5\"`\n"+
+				"}")
+
+			cv.So(string(src), ShouldContainModuloWhiteSpace, "type Shape struct {\n"+
+				"Area float64 `capid:\"0\"`\n"+
+				"Circle float64 `capid:\"1\"` // union member\n"+
+				"Square float64 `capid:\"2\"` // union member\n"+
+				"InfoLabel string `capid:\"3\"`\n"+
+				"InfoTags []PersonAddress `capid:\"4\"`\n"+
+				"}")
+
+			// the union; Age's default is carried over in a capdefault tag.
+			cv.So(len(warnings), cv.ShouldEqual, 1)
+		})
+
+		cv.Convey("then the Go structs it writes should go through the Extractor, keeping the ordinals", func() {
+
+			schema, err := ParseCapnpSchema("their.capnp", []byte(theirSchema))
+			cv.So(err, cv.ShouldEqual, nil)
+			src, _, err := GoFromCapnp(schema, "main", true)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err = x.ExtractStructsFromOneFile(src, "")
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(x.srs["Person"] != nil, cv.ShouldEqual, true)
+			cv.So(x.srs["Person"].capIdMap[4].goName, cv.ShouldEqual, "Home")
+			cv.So(x.srs["Shape"].capIdMap[4].capType, cv.ShouldEqual, "List(PersonAddressCapn)")
+			cv.So(x.srs["Person"].capIdMap[1].defaultValue, cv.ShouldEqual, "18")
+			cv.So(x.srs["Person"].capIdMap[3].capType, cv.ShouldEqual, "UInt16")
+			cv.So(x.srs["Person"].capIdMap[3].namedType, cv.ShouldEqual, "PersonColor")
+		})
+	})
+
+	cv.Convey("Given a .capnp schema that Go structs can't reproduce wire-compatibly", t, func() {
+		cv.Convey("then GoFromCapnp should refuse it, listing each problem, unless allowed to be lossy", func() {
+			schema, err := ParseCapnpSchema("lossy.capnp", []byte("struct S {\n  a @0 :Void;\n  union {\n    b @1 :Int8;\n    c @2 :Text;\n  }\n}\n"))
+			cv.So(err, cv.ShouldEqual, nil)
+			src, _, err := GoFromCapnp(schema, "main", false)
+			cv.So(src == nil, cv.ShouldEqual, true)
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(err.Error(), cv.ShouldEqual, "the Go structs for 'lossy.capnp' would not be wire-compatible with it:\n"+
+				"  lossy.capnp:1: struct S: the members of its unnamed union become ordinary fields, and the union's tag is lost.\n"+
+				"  lossy.capnp:2: field S.a is Void, which bambam writes as a Bool, taking a bit that Void doesn't.\n"+
+				"(-from-capnp-lossy converts it anyway)")
+
+			src, warnings, err := GoFromCapnp(schema, "main", true)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(warnings), cv.ShouldEqual, 2)
+			cv.So(string(src), ShouldContainModuloWhiteSpace, "A bool `capid:\"0\"` // Void; bambam has no Void, so a bool holds its place")
+		})
+	})
+
+	cv.Convey("Given a .capnp schema with a field of unknown type", t, func() {
+		cv.Convey("then GoFromCapnp should report the file, line and field", func() {
+			schema, err := ParseCapnpSchema("bad.capnp", []byte("struct S {\n  a @0 :Mystery;\n}\n"))
+			cv.So(err, cv.ShouldEqual, nil)
+			_, _, err = GoFromCapnp(schema, "main", true)
+			cv.So(err != nil, cv.ShouldEqual, true)
+			cv.So(err.Error(), cv.ShouldEqual, "bad.capnp:2: field S.a: unknown type 'Mystery'")
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GenerateTestCode returns, keyed by name, the random-value populators
+// that the -gentests round-trip tests and benchmarks are built on: one
+// bambamRandomX per struct, plus one bambamRandSliceY per slice type.
+func (x *Extractor) GenerateTestCode() map[string][]byte {
+
+	helpers := make(map[string][]byte)
+
+	for _, s := range x.srs {
+		data := &RandomStruct{GoName: s.goName, PromotedPtrs: s.PromotedPtrs()}
+		for _, u := range s.buildUnions() {
+			ru := RandomUnion{Name: u.Name, Choices: len(u.Members) + 1}
+			for _, f := range u.Members {
+				ru.Fields = append(ru.Fields, RandomField{
+					Name:   f.goName,
+					GoType: f.goType,
+					Expr:   x.randExpr(fieldGoTypeSeq(f), helpers),
+				})
+			}
+			data.Unions = append(data.Unions, ru)
+		}
+		for _, f := range s.fld {
+			if f.union != "" {
+				continue
+			}
+			expr := x.randExpr(fieldGoTypeSeq(f), helpers)
+			if f.keepNil {
+				expr = x.randKeepNilExpr(f.goTypeSeq, helpers)
+			}
+			if f.namedType != "" && !f.isList && expr != "" {
+				// type Kind uint16: a uint16 needs converting to a Kind.
+				expr = fmt.Sprintf("%s(%s)", f.namedType, expr)
+			}
+			data.Fields = append(data.Fields, RandomField{
+				Name:   f.goName,
+				GoType: f.goType,
+				Expr:   expr,
+			})
+		}
+		helpers["bambamRandom"+UppercaseFirstLetter(s.goName)] = x.render("randomStruct", data)
+	}
+	return helpers
+}
+
+// RandomStruct is dot for the randomStruct template.
+type RandomStruct struct {
+	GoName       string
+	PromotedPtrs []promotedPtr // allocated before Fields are set
+	Fields       []RandomField
+	Unions       []RandomUnion
+}
+
+// RandomUnion sets one of its Fields, or none when the r.Intn(Choices)
+// draw is past them, since at most one field of a union may be set.
+type RandomUnion struct {
+	Name    string
+	Choices int
+	Fields  []RandomField
+}
+
+// RandomField is one assignment in a bambamRandomX: s.Name = Expr.
+// An empty Expr means we can't populate GoType, so the field is left zero.
+type RandomField struct {
+	Name   string
+	GoType string
+	Expr   string
+}
+
+// RandomSlice is dot for the randomSlice and randomPtr templates.
+type RandomSlice struct {
+	Name   string // e.g. bambamRandSliceInt, bambamRandPtrBig
+	GoType string // e.g. []int, *Big
+	Elem   string // expression making one random element, or the *Big
+}
+
+// fieldGoTypeSeq returns f's go type sequence, restoring the leading
+// "*" that embedded pointer fields keep only in their goTypePrefix.
+func fieldGoTypeSeq(f *Field) []string {
+	if f.embedded && isPointerType(f.goTypePrefix) && f.goTypeSeq[0] != "*" {
+		return append([]string{"*"}, f.goTypeSeq...)
+	}
+	return f.goTypeSeq
+}
+
+// randExpr returns a go expression, in terms of r and depth, that makes
+// a random value of the type goSeq. Slice helpers it needs are added to
+// helpers. Returns "" for types we don't know how to populate.
+func (x *Extractor) randExpr(goSeq []string, helpers map[string][]byte) string {
+
+	switch goSeq[0] {
+	case "*":
+		if len(goSeq) == 2 && x.srs[goSeq[1]] != nil {
+			name := "bambamRand" + TypeSeqName(goSeq)
+			if _, already := helpers[name]; !already {
+				helpers[name] = x.render("randomPtr", &RandomSlice{
+					Name:   name,
+					GoType: strings.Join(goSeq, ""),
+					Elem:   fmt.Sprintf("bambamRandom%s(r, depth)", UppercaseFirstLetter(goSeq[1])),
+				})
+			}
+			return name + "(r, depth+1)"
+		}
+		return ""
+
+	case "[]":
+		name := "bambamRand" + TypeSeqName(goSeq)
+		if _, already := helpers[name]; already {
+			return name + "(r, depth+1)"
+		}
+		if isStructPtr(goSeq[1:]) {
+			// a nil element would load back as &T{}, failing the
+			// round trip, unless the field is tagged capnil:"keep".
+			helpers[name] = x.render("randomSlice", &RandomSlice{
+				Name:   name,
+				GoType: strings.Join(goSeq, ""),
+				Elem:   fmt.Sprintf("bambamRandom%s(r, depth+1)", UppercaseFirstLetter(goSeq[2])),
+			})
+			return name + "(r, depth+1)"
+		}
+		// reserve the name before recursing on the element type.
+		helpers[name] = nil
+		elem := x.randExpr(goSeq[1:], helpers)
+		if elem == "" {
+			delete(helpers, name)
+			return ""
+		}
+		helpers[name] = x.render("randomSlice", &RandomSlice{
+			Name:   name,
+			GoType: strings.Join(goSeq, ""),
+			Elem:   elem,
+		})
+		return name + "(r, depth+1)"
+	}
+
+	goType := goSeq[0]
+	switch goType {
+	case "string":
+		return "bambamRandString(r)"
+	case "bool":
+		return "r.Intn(2) == 1"
+	case "float32":
+		return "r.Float32()"
+	case "float64":
+		return "r.Float64()"
+	case "int", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "byte",
+		"uint", "uintptr", "rune":
+		return fmt.Sprintf("%s(r.Int63())", goType)
+	case "complex64":
+		return "complex(r.Float32(), r.Float32())"
+	case "complex128":
+		return "complex(r.Float64(), r.Float64())"
+	}
+
+	if x.srs[goType] != nil {
+		return fmt.Sprintf("*bambamRandom%s(r, depth+1)", UppercaseFirstLetter(goType))
+	}
+	return ""
+}
+
+// randKeepNilExpr is randExpr for a []*T field tagged capnil:"keep",
+// whose nil elements load back as nil: its elements are nil now and then.
+func (x *Extractor) randKeepNilExpr(goSeq []string, helpers map[string][]byte) string {
+	name := "bambamRand" + TypeSeqName(goSeq) + "OrNil"
+	if _, already := helpers[name]; !already {
+		helpers[name] = x.render("randomSlice", &RandomSlice{
+			Name:   name,
+			GoType: strings.Join(goSeq, ""),
+			Elem:   x.randExpr(goSeq[1:], helpers),
+		})
+	}
+	return name + "(r, depth+1)"
+}
+
+// WriteToTests writes a complete _test.go file for the generated package:
+// a round-trip test asserting reflect.DeepEqual after Save then Load,
+// BenchmarkXSave/BenchmarkXLoad, and a FuzzXLoad target, for every struct.
+func (x *Extractor) WriteToTests(w io.Writer) (n int64, err error) {
+
+	var m int
+
+	// sort structs alphabetically to get a stable (testable) ordering.
+	sortedStructs := ByGoName(make([]*Struct, 0, len(x.srs)))
+	for _, strct := range x.srs {
+		sortedStructs = append(sortedStructs, strct)
+	}
+	sort.Sort(ByGoName(sortedStructs))
+
+	// random values must load, so they nest no deeper than -max-load-depth allows.
+	maxDepth := 8
+	if x.maxLoadDepth > 0 && x.maxLoadDepth < maxDepth {
+		maxDepth = x.maxLoadDepth
+	}
+	m, err = w.Write(x.render("testsHeader", &FileData{PkgName: x.pkgName, RandomMaxDepth: maxDepth}))
+	n += int64(m)
+	if err != nil {
+		return
+	}
+
+	for _, s := range sortedStructs {
+		m, err = w.Write(x.render("structTests", s))
+		n += int64(m)
+		if err != nil {
+			return
+		}
+	}
+
+	helpers := x.GenerateTestCode()
+	a := make([]AlphaHelper, 0, len(helpers))
+	for k, v := range helpers {
+		a = append(a, AlphaHelper{Name: k, Code: v})
+	}
+	sort.Sort(AlphaHelperSlice(a))
+
+	for _, help := range a {
+		m, err = w.Write(help.Code)
+		n += int64(m)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func ExtractTestsString(src string) string {
+
+	x := NewExtractor()
+	defer x.Cleanup()
+	_, err := ExtractStructs("", "package main; "+src, x)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = x.WriteToTests(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return string(buf.Bytes())
+}
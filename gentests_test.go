@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestGenTestsPopulatesEveryFieldKind(t *testing.T) {
+
+	cv.Convey("Given structs with nested slices, a list of lists, and an embedded struct", t, func() {
+		cv.Convey("then -gentests should write a random populator covering each, plus round-trip tests and benchmarks", func() {
+
+			ex0 := `
+type Inner struct {
+  C int
+}
+type Outer struct {
+  Inner
+  Names  []string
+  Matrix [][]float64
+  Ins    []*Inner
+  Best   *Inner
+}
+`
+			out0 := ExtractTestsString(ex0)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func bambamRandomOuter(r *rand.Rand, depth int) *Outer {
+	s := &Outer{}
+	s.Inner = *bambamRandomInner(r, depth+1)
+	s.Names = bambamRandSliceString(r, depth+1)
+	s.Matrix = bambamRandSliceSliceFloat64(r, depth+1)
+	s.Ins = bambamRandSlicePtrInner(r, depth+1)
+	s.Best = bambamRandPtrInner(r, depth+1)
+	return s
+}
+`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func bambamRandSliceSliceFloat64(r *rand.Rand, depth int) [][]float64 {
+	if depth > bambamMaxDepth {
+		// empty, not nil: an empty list loads as an empty slice.
+		return [][]float64{}
+	}
+	v := make([][]float64, 1+r.Intn(3))
+	for i := range v {
+		v[i] = bambamRandSliceFloat64(r, depth+1)
+	}
+	return v
+}
+`)
+			// a nil element of a []*Inner would load back as &Inner{}.
+			cv.So(out0, ShouldContainModuloWhiteSpace, `v[i] = bambamRandomInner(r, depth+1)`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func bambamRandPtrInner(r *rand.Rand, depth int) *Inner {
+	if depth > bambamMaxDepth || r.Intn(4) == 0 {
+		return nil
+	}
+	return bambamRandomInner(r, depth)
+}
+`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+		v2 := &Outer{}
+		if err := v2.Load(&buf); err != nil {
+			t.Fatalf("trial %d: Load: %s", trial, err)
+		}
+		if !reflect.DeepEqual(v, v2) {`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `func BenchmarkOuterSave(b *testing.B) {`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `func BenchmarkInnerLoad(b *testing.B) {`)
+		})
+	})
+}
+
+func TestGenTestsEmitsFuzzTargets(t *testing.T) {
+
+	cv.Convey("Given a struct to generate tests for", t, func() {
+		cv.Convey("then -gentests should also write a FuzzXLoad target, seeded from Save()d random values, checking that re-Save is stable", func() {
+
+			ex0 := `
+type Msg struct {
+  Id   int64
+  Tags []string
+}
+`
+			out0 := ExtractTestsString(ex0)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func FuzzMsgLoad(f *testing.F) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 8; i++ {
+		var buf bytes.Buffer
+		bambamRandomMsg(r, 0).Save(&buf)
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := &Msg{}
+		if err := v.Load(bytes.NewReader(data)); err != nil {
+			return
+		}
+`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `if !bytes.Equal(first.Bytes(), second.Bytes()) {`)
+		})
+	})
+}
+
+func Test019GeneratedRoundTripTestsPass(t *testing.T) {
+
+	tdir := NewTempDir()
+	// comment the defer out to debug any gentests failures.
+	defer tdir.Cleanup()
+
+	err := exec.Command("cp", "rw.go.txt", tdir.DirPath+"/rw.go").Run()
+	if err != nil {
+		panic(err)
+	}
+
+	MainArgs([]string{os.Args[0], "-gentests", "-o", tdir.DirPath, "rw.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
+
+	cv.Convey("Given bambam -gentests output for rw.go.txt", t, func() {
+		cv.Convey("then go test should run the generated round-trip tests and pass", func() {
+
+			tdir.MoveTo()
+
+			err = exec.Command("capnpc", "-ogo", "schema.capnp").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			err = exec.Command("go", "test").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+		})
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "embed"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// goCapnp is the go.capnp schema that every schema.capnp imports for
+// its $Go.package and $Go.import annotations. It is compiled into the
+// binary, so bambam works wherever it is installed.
+//
+//go:embed go.capnp
+var goCapnp []byte
+
+// DefaultGoCapnpImport is the path schema.capnp imports go.capnp from:
+// the copy WriteGoCapnp puts next to it.
+const DefaultGoCapnpImport = "go.capnp"
+
+// WriteGoCapnp writes the embedded go.capnp into dir.
+func WriteGoCapnp(dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, "go.capnp"), goCapnp, 0644)
+}
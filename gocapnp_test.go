@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestGoCapnpIsEmbedded(t *testing.T) {
+
+	cv.Convey("Given the go.capnp compiled into bambam", t, func() {
+		cv.Convey("then WriteGoCapnp should write it, byte for byte, wherever the output goes", func() {
+
+			dir, err := ioutil.TempDir("", "bambam-gocapnp")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			cv.So(WriteGoCapnp(dir), cv.ShouldEqual, nil)
+			written, err := ioutil.ReadFile(filepath.Join(dir, "go.capnp"))
+			cv.So(err, cv.ShouldEqual, nil)
+			source, err := ioutil.ReadFile("go.capnp")
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(bytes.Equal(written, source), cv.ShouldEqual, true)
+		})
+	})
+}
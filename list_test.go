@@ -20,21 +20,25 @@ type s1 struct {
   struct S1Capn { names  @0:   List(Text); } 
 
     func (s *s1) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *s1) SaveWith(seg *capn.Segment, w io.Writer) error {
     	s1GoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
       
     func (s *s1) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootS1Capn(capMsg)
-        S1CapnToGo(z, s)
-        return nil
+    	S1CapnToGo(z, s)
+    	return nil
     }
   
   func S1CapnToGo(src S1Capn, dest *s1) *s1 { 
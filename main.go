@@ -1,25 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
 func use() {
 	fmt.Fprintf(os.Stderr, "\nuse: bambam -o outdir -p package myGoSourceFile.go myGoSourceFile2.go ...\n")
+	fmt.Fprintf(os.Stderr, "     or: bambam [options] [packageDir]  # e.g. //go:generate bambam\n")
 	fmt.Fprintf(os.Stderr, "     # Bambam makes it easy to use Capnproto serialization[1] from Go.\n")
 	fmt.Fprintf(os.Stderr, "     # Bambam reads .go files and writes a .capnp schema and Go bindings.\n")
 	fmt.Fprintf(os.Stderr, "     # options:\n")
-	fmt.Fprintf(os.Stderr, "     #   -o=\"odir\" specifies the directory to write to (created if need be).\n")
-	fmt.Fprintf(os.Stderr, "     #   -p=\"main\" specifies the package header to write (e.g. main, mypkg).\n")
+	fmt.Fprintf(os.Stderr, "     #   -o=\"odir\" specifies the directory to write to (created if need be). For a package directory, the default is that directory.\n")
+	fmt.Fprintf(os.Stderr, "     #   -p=\"main\" specifies the package header to write (e.g. main, mypkg). For a package directory, the default is that package's name.\n")
 	fmt.Fprintf(os.Stderr, "     #   -X exports private fields of Go structs. Default only maps public fields.\n")
 	fmt.Fprintf(os.Stderr, "     #   -version   shows build version with git commit hash.\n")
 	fmt.Fprintf(os.Stderr, "     #   -debug     print lots of debug info as we process.\n")
-	fmt.Fprintf(os.Stderr, "     #   -OVERWRITE modify .go files in-place, adding capid tags (write to -o dir by default).\n")
-	fmt.Fprintf(os.Stderr, "     # required: at least one .go source file for struct definitions. Must be last, after options.\n")
+	fmt.Fprintf(os.Stderr, "     #   -OVERWRITE modify .go files in-place, adding capid tags (write to -o dir by default). The originals are backed up under -o dir/bk, as .go.bak files.\n")
+	fmt.Fprintf(os.Stderr, "     #   -diff      print the unified diff of the capid tags bambam would add to the .go files, and write nothing.\n")
+	fmt.Fprintf(os.Stderr, "     #   -gentests  also write translateCapn_test.go: round-trip tests, benchmarks and fuzz targets for every struct.\n")
+	fmt.Fprintf(os.Stderr, "     #   -include='Msg*' only serialize matching structs, and the structs they refer to. Glob, or /regexp/. Repeatable.\n")
+	fmt.Fprintf(os.Stderr, "     #   -exclude='*Internal' leave out matching structs. Glob, or /regexp/. Repeatable.\n")
+	fmt.Fprintf(os.Stderr, "     #   -flatten   inline the fields of embedded structs into the struct that embeds them, as if each were tagged capid:\"flatten\".\n")
+	fmt.Fprintf(os.Stderr, "     #   -max-load-depth=64  make Load fail on messages that nest recursive structs (trees, linked lists) deeper than this. Default 0: no limit.\n")
+	fmt.Fprintf(os.Stderr, "     #   -name-from=json  name capnp fields after their json tags, and skip fields tagged json:\"-\" (any tag key works).\n")
+	fmt.Fprintf(os.Stderr, "     #   -consts    write every exported Go constant to the schema as a capnp const, not just those marked // bambam:const.\n")
+	fmt.Fprintf(os.Stderr, "     #   -using='Json = import \"/capnp/compat/json.capnp\"' add a using line to the schema header. Repeatable.\n")
+	fmt.Fprintf(os.Stderr, "     #   -file-annot='$Cxx.namespace(\"app\")' add a file annotation to the schema header. Repeatable.\n")
+	fmt.Fprintf(os.Stderr, "     #   -templates=\"dir\" override the code generation templates with dir/*.tmpl; see templates/README.md.\n")
+	fmt.Fprintf(os.Stderr, "     #   -compile   also run capnp compile -ogo on schema.capnp, then type-check the output package with go/types.\n")
+	fmt.Fprintf(os.Stderr, "     #   -go-capnp-import=\"/go.capnp\" import go.capnp from this path in schema.capnp, e.g. a system-installed copy on capnp's import path, instead of writing bambam's copy to the -o dir.\n")
+	fmt.Fprintf(os.Stderr, "     #   -from-capnp=\"their.capnp\" write Go structs for an existing schema into the -o dir, then translators for them.\n")
+	fmt.Fprintf(os.Stderr, "     #   -from-capnp-lossy  convert a -from-capnp schema even where bambam can't stay wire-compatible with it (unnamed unions, unions of non-structs, Void fields, non-literal defaults), with a warning for each.\n")
+	fmt.Fprintf(os.Stderr, "     # input: .go source files for struct definitions, or one package directory (default: the current directory, skipping _test.go and generated files). Must be last, after options.\n")
 	fmt.Fprintf(os.Stderr, "     #\n")
 	fmt.Fprintf(os.Stderr, "     # [1] https://github.com/glycerine/go-capnproto \n")
 	fmt.Fprintf(os.Stderr, "\n")
@@ -36,9 +54,6 @@ func MainArgs(args []string) {
 	os.Args = args
 
 	flag.Usage = use
-	if len(os.Args) < 2 {
-		use()
-	}
 
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	debug := flag.Bool("debug", false, "print lots of debug info as we process.")
@@ -47,6 +62,23 @@ func MainArgs(args []string) {
 	pkg := flag.String("p", "main", "specify package for generated code")
 	privs := flag.Bool("X", false, "export private as well as public struct fields")
 	overwrite := flag.Bool("OVERWRITE", false, "replace named .go files with capid tagged versions.")
+	diffOnly := flag.Bool("diff", false, "print the diff of the capid tags that would be added, and write nothing")
+	gentests := flag.Bool("gentests", false, "write round-trip tests, benchmarks and fuzz targets to translateCapn_test.go")
+	var include, exclude StructPatternList
+	flag.Var(&include, "include", "serialize only structs matching this glob or /regexp/, plus the structs they refer to")
+	flag.Var(&exclude, "exclude", "leave out structs matching this glob or /regexp/")
+	flatten := flag.Bool("flatten", false, "inline the fields of embedded structs, as if tagged capid:\"flatten\"")
+	maxLoadDepth := flag.Int("max-load-depth", 0, "make Load fail on recursive structs nested deeper than this; 0 for no limit")
+	nameFrom := flag.String("name-from", "", "take capnp field names from this struct tag, e.g. json")
+	fromCapnp := flag.String("from-capnp", "", "generate Go structs (and then translators) from this .capnp schema")
+	fromCapnpLossy := flag.Bool("from-capnp-lossy", false, "with -from-capnp, convert a schema even where the result can't be wire-compatible with it, warning about each such part")
+	compile := flag.Bool("compile", false, "run capnp compile -ogo on schema.capnp, and type-check the result")
+	goCapnpImport := flag.String("go-capnp-import", "", "import go.capnp from this path (e.g. /go.capnp) instead of writing a copy next to schema.capnp")
+	consts := flag.Bool("consts", false, "write every exported Go constant to the schema, as if marked // bambam:const")
+	var usings, fileAnnotations StringList
+	flag.Var(&usings, "using", "add a using line to schema.capnp, e.g. 'Json = import \"/capnp/compat/json.capnp\"'")
+	flag.Var(&fileAnnotations, "file-annot", "add a file annotation to schema.capnp, e.g. '$Cxx.namespace(\"app\")'")
+	templates := flag.String("templates", "", "directory of .tmpl files overriding the default code generation templates")
 	flag.Parse()
 
 	if debug != nil {
@@ -58,12 +90,49 @@ func MainArgs(args []string) {
 		os.Exit(0)
 	}
 
+	// which flags were given, to tell defaults from choices.
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// all the rest are input .go files, or one package directory.
+	inputFiles := flag.Args()
+
+	if len(inputFiles) == 0 && *fromCapnp == "" {
+		// e.g. //go:generate bambam
+		inputFiles = []string{"."}
+	}
+
+	pkgDir := ""
+	if len(inputFiles) == 1 && DirExists(inputFiles[0]) {
+		pkgDir = inputFiles[0]
+		pkgName, files, err := PackageGoFiles(pkgDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam: reading the package in '%s': %s\n", pkgDir, err)
+			os.Exit(1)
+		}
+		inputFiles = files
+		// write into the package itself, unless told otherwise.
+		if !given["o"] {
+			*outdir = pkgDir
+		}
+		if !given["p"] {
+			*pkg = pkgName
+		}
+	}
+
+	for _, fn := range inputFiles {
+		if !strings.HasSuffix(fn, ".go") && !strings.HasSuffix(fn, ".go.txt") {
+			fmt.Fprintf(os.Stderr, "error: bambam input file '%s' did not end in '.go' or '.go.txt'. Give either .go files, or one package directory.\n", fn)
+			os.Exit(1)
+		}
+	}
+
 	if outdir == nil || *outdir == "" {
 		fmt.Fprintf(os.Stderr, "required -o option missing. Use bambam -o <dirname> myfile.go # to specify the output directory.\n")
 		use()
 	}
 
-	if !DirExists(*outdir) {
+	if !*diffOnly && !DirExists(*outdir) {
 		err := os.MkdirAll(*outdir, 0755)
 		if err != nil {
 			panic(err)
@@ -75,21 +144,6 @@ func MainArgs(args []string) {
 		use()
 	}
 
-	// all the rest are input .go files
-	inputFiles := flag.Args()
-
-	if len(inputFiles) == 0 {
-		fmt.Fprintf(os.Stderr, "bambam needs at least one .go golang source file to process specified on the command line.\n")
-		os.Exit(1)
-	}
-
-	for _, fn := range inputFiles {
-		if !strings.HasSuffix(fn, ".go") && !strings.HasSuffix(fn, ".go.txt") {
-			fmt.Fprintf(os.Stderr, "error: bambam input file '%s' did not end in '.go' or '.go.txt'.\n", fn)
-			os.Exit(1)
-		}
-	}
-
 	x := NewExtractor()
 	x.fieldPrefix = "   "
 	x.fieldSuffix = "\n"
@@ -100,13 +154,113 @@ func MainArgs(args []string) {
 	if overwrite != nil {
 		x.overwrite = *overwrite
 	}
+	if nameFrom != nil {
+		x.nameFrom = *nameFrom
+	}
+	if flatten != nil {
+		x.flattenEmbedded = *flatten
+	}
+	if consts != nil {
+		x.allConsts = *consts
+	}
+	if maxLoadDepth != nil {
+		if *maxLoadDepth < 0 {
+			fmt.Fprintf(os.Stderr, "bambam: -max-load-depth must be 0 (no limit) or more, not %d\n", *maxLoadDepth)
+			os.Exit(1)
+		}
+		x.maxLoadDepth = *maxLoadDepth
+	}
+	if goCapnpImport != nil && *goCapnpImport != "" {
+		x.goCapnpImport = *goCapnpImport
+	}
+	x.usings = usings
+	x.fileAnnotations = fileAnnotations
+	x.include = include
+	x.exclude = exclude
+	if templates != nil && *templates != "" {
+		t, err := LoadTemplates(*templates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam: could not load -templates: %s\n", err)
+			os.Exit(1)
+		}
+		x.tmpl = t
+	}
 
+	if *fromCapnp != "" {
+		if *diffOnly {
+			fmt.Fprintf(os.Stderr, "bambam: -diff does not apply to -from-capnp; there are no .go files to tag.\n")
+			os.Exit(1)
+		}
+		if x.overwrite {
+			fmt.Fprintf(os.Stderr, "bambam: -OVERWRITE does not apply to -from-capnp; the Go structs are written to the -o directory.\n")
+			os.Exit(1)
+		}
+		goFn, src, warnings, err := GoFromCapnpFile(*fromCapnp, *pkg, *fromCapnpLossy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam -from-capnp: %s\n", err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "bambam -from-capnp: warning: %s\n", w)
+		}
+		// the capid tagged copy of goFn that every run writes into
+		// the output directory is the Go we generated.
+		_, err = x.ExtractStructsFromOneFile(src, goFn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam -from-capnp: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := x.NoteNamedTypesIn(inputFiles); err != nil {
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
+	}
 	for _, inFile := range inputFiles {
 		_, err := x.ExtractStructsFromOneFile(nil, inFile)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	// before any output, so a bad constant leaves nothing half written.
+	if err := x.EvalConsts(); err != nil {
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
+	}
+	if pkgDir != "" {
+		// tagged copies of a package's files go straight into the output directory.
+		for _, sf := range x.srcFiles {
+			sf.outName = filepath.Base(sf.filename)
 		}
 	}
+
+	err := x.FlattenEmbedded()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = x.SelectStructs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *diffOnly {
+		// number the fields, then show the tags they would get.
+		_, err = x.WriteToSchema(ioutil.Discard)
+		if err == nil {
+			err = x.WriteCapidDiff(os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam -diff: %s\n", err)
+			os.Exit(1)
+		}
+		x.Cleanup()
+		return
+	}
+
 	// get rid of default tmp dir
 	x.compileDir.Cleanup()
 
@@ -125,39 +279,109 @@ func MainArgs(args []string) {
 
 	_, err = x.WriteToSchema(schemaFile)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
 	}
 
 	fmt.Fprintf(schemaFile, "\n")
 	fmt.Fprintf(schemaFile, "##compile with:\n\n##\n##\n##   capnp compile -ogo %s\n\n", schemaFN)
 
+	// sanity check what we wrote, without needing the capnp tool.
+	written, err := ioutil.ReadFile(schemaFN)
+	if err != nil {
+		panic(err)
+	}
+	if errs := ValidateCapnpFile(schemaFN, written); len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "bambam: the schema written to '%s' is not valid capnp:\n", schemaFN)
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "  %s\n", e)
+		}
+		os.Exit(1)
+	}
+
 	// translator library of go functions is separate from the schema
 
 	translateFn := x.compileDir.DirPath + "/translateCapn.go"
-	translatorFile, err := os.Create(translateFn)
+	var translators bytes.Buffer
+	translators.Write(x.render("header", &FileData{PkgName: x.pkgName, ImportFmt: x.translatorsUseFmt()}))
+
+	_, err = x.WriteToTranslators(&translators)
 	if err != nil {
 		panic(err)
 	}
-	defer translatorFile.Close()
-	fmt.Fprintf(translatorFile, `package %s
-
-import (
-  capn "github.com/glycerine/go-capnproto"
-  "io"
-)
 
-`, x.pkgName)
+	_, err = x.WriteToViews(&translators)
+	if err != nil {
+		panic(err)
+	}
 
-	_, err = x.WriteToTranslators(translatorFile)
+	_, err = x.WriteToEncoder(&translators)
 	if err != nil {
 		panic(err)
 	}
 
+	err = x.writeFormatted(translateFn, translators.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
+	}
+
+	if gentests != nil && *gentests {
+		var tests bytes.Buffer
+		_, err = x.WriteToTests(&tests)
+		if err != nil {
+			panic(err)
+		}
+		err = x.writeFormatted(x.compileDir.DirPath+"/translateCapn_test.go", tests.Bytes())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	err = x.CopySourceFilesAddCapidTag()
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "bambam: %s\n", err)
+		os.Exit(1)
 	}
 
-	exec.Command("cp", "-p", "go.capnp", x.compileDir.DirPath).Run()
+	if x.goCapnpImport == DefaultGoCapnpImport {
+		err = WriteGoCapnp(x.compileDir.DirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam: writing go.capnp: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if compile != nil && *compile {
+		err = CompileSchema(x.compileDir.DirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam -compile: %s\n", err)
+			os.Exit(1)
+		}
+		errs, err := TypeCheckDir(x.compileDir.DirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bambam -compile: type-checking '%s': %s\n", x.compileDir.DirPath, err)
+			os.Exit(1)
+		}
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "bambam -compile: the generated package in '%s' does not type-check:\n", x.compileDir.DirPath)
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "  %s\n", e)
+			}
+			os.Exit(1)
+		}
+	}
 	fmt.Printf("generated files in '%s'\n", x.compileDir.DirPath)
 }
+
+// writeFormatted gofmts the generated Go in src and writes it to fn.
+// Rather than write code that won't compile, it writes nothing and
+// returns the error, which names the struct at fault.
+func (x *Extractor) writeFormatted(fn string, src []byte) error {
+	formatted, err := x.FormatGenerated(fn, src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, formatted, 0644)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// NoteNamedTypes records the named slice, map and basic types declared
+// in f, like type IDs []int64 and type Kind uint16, for ResolveNamedType. It runs before the
+// structs of f are extracted, so a field may use a type declared
+// further down the file, or in a file extracted earlier; see
+// NoteNamedTypesIn for the files extracted later.
+func (x *Extractor) NoteNamedTypes(f *ast.File) {
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range d.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			switch ty := ts.Type.(type) {
+			case *ast.ArrayType:
+				if ty.Len == nil {
+					x.namedTypes[ts.Name.Name] = ty
+				}
+			case *ast.MapType:
+				x.namedTypes[ts.Name.Name] = ty
+			case *ast.Ident:
+				if _, isComplex := complexPartTypes[ty.Name]; IsIntrinsicGoType(ty.Name) && !isComplex {
+					x.namedTypes[ts.Name.Name] = ty
+				}
+			}
+		}
+	}
+}
+
+// NoteNamedTypesIn runs NoteNamedTypes on each of the Go files
+// fnames, ahead of extracting any of them, so that a field may use a
+// named type declared in an input file extracted after its own.
+func (x *Extractor) NoteNamedTypesIn(fnames []string) error {
+	for _, fn := range fnames {
+		f, err := parser.ParseFile(token.NewFileSet(), fn, nil, 0)
+		if err != nil {
+			return err
+		}
+		x.NoteNamedTypes(f)
+	}
+	return nil
+}
+
+// ResolveNamedType replaces a field type that names a slice or basic
+// type, like IDs for type IDs []int64 or Kind for type Kind uint16, by
+// its underlying type, as GetTypeAsString gives it for []int64, and
+// returns the name as named. Other types come back unchanged, with
+// named empty.
+//
+// Only the whole type of a field is resolved: []IDs, *IDs and []Kind
+// are errors, as is a named slice of a named slice, like type Grid
+// []IDs, and any named map type, since bambam doesn't translate maps.
+func (x *Extractor) ResolveNamedType(structName, fieldName, prefix, base string, goTypeSeq []string) (string, string, []string, string, error) {
+	declared := prefix + base
+	for _, t := range goTypeSeq {
+		switch x.namedTypes[t].(type) {
+		case *ast.MapType:
+			return "", "", nil, "", fmt.Errorf("struct '%s': field '%s' has type %s, and %s is a map type; bambam doesn't support Go maps", structName, fieldName, declared, t)
+		case *ast.ArrayType:
+			if len(goTypeSeq) > 1 {
+				return "", "", nil, "", fmt.Errorf("struct '%s': field '%s' has type %s; bambam resolves a named slice type like %s only when it is the whole type of a field", structName, fieldName, declared, t)
+			}
+		case *ast.Ident:
+			if len(goTypeSeq) > 1 {
+				return "", "", nil, "", fmt.Errorf("struct '%s': field '%s' has type %s; bambam resolves a named basic type like %s only when it is the whole type of a field", structName, fieldName, declared, t)
+			}
+		}
+	}
+	if basic, ok := x.namedTypes[base].(*ast.Ident); ok {
+		return "", basic.Name, []string{basic.Name}, base, nil
+	}
+	under, ok := x.namedTypes[base].(*ast.ArrayType)
+	if !ok {
+		return prefix, base, goTypeSeq, "", nil
+	}
+
+	uprefix, ubase, useq := GetTypeAsString(under, "", []string{})
+	if ubase == "" {
+		return "", "", nil, "", fmt.Errorf("struct '%s': field '%s' has type %s, which is %s; bambam can't translate that", structName, fieldName, base, types.ExprString(under))
+	}
+	if _, isNamed := x.namedTypes[ubase]; isNamed {
+		return "", "", nil, "", fmt.Errorf("struct '%s': field '%s' has type %s, which is %s; bambam resolves only one level of named slice or map type", structName, fieldName, base, types.ExprString(under))
+	}
+	return uprefix, ubase, useq, base, nil
+}
+
+// NamedType is the field's Go type when that is a named slice or basic
+// type, IDs for type IDs []int64 or Kind for type Kind uint16, or else
+// empty.
+func (f *Field) NamedType() string { return f.namedType }
+
+// GoDeclType is the field's Go type as declared: the NamedType if it
+// has one, or else the GoTypeString.
+func (f *Field) GoDeclType() string {
+	if f.namedType != "" {
+		return f.namedType
+	}
+	return f.GoTypeString()
+}
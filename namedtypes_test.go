@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestNamedSliceTypes(t *testing.T) {
+
+	cv.Convey("Given fields whose types are named slice types", t, func() {
+		cv.Convey("then the schema should use their underlying types, and the translators should make the named types", func() {
+			in := `
+type S struct {
+	I IDs
+	M Matrix
+	P Pts
+	N Names
+}
+type IDs []int64
+type Matrix [][]float64
+type Pts []*Pt
+type Names []string
+type Pt struct { X int }
+`
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+in, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct SCapn { i @0: List(Int64); m @1: List(List(Float64)); p @2: List(PtCapn); n @3: List(Text); }`)
+
+			x.GenerateTranslators()
+			toGo := string(x.ToGoCodeFor("S"))
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.I = make(IDs, n)`)
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.M = make(Matrix, n)`)
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.M[i] = Float64ListToSliceFloat64(capn.Float64List(src.M().At(i)))`)
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.P = make(Pts, n)`)
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `dest.N = Names(src.N().ToArray())`)
+			cv.So(string(x.ToCapnCodeFor("S")), ShouldContainModuloWhiteSpace, `
+		for _, ele := range src.P {
+			if ele != nil {
+				plist.Set(i, capn.Object(PtGoToCapn(seg, ele)))
+			}`)
+		})
+	})
+
+	cv.Convey("Given a field whose type is a named basic type, like an enum", t, func() {
+		cv.Convey("then the schema should use the underlying type, and the translators, views and random values should convert to it", func() {
+			in := "type Kind uint16\nconst ( KindA Kind = 0; KindB Kind = 1 )\ntype S struct { K Kind }"
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+in, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct SCapn { k @0: UInt16; }`)
+
+			x.GenerateTranslators()
+			cv.So(string(x.ToGoCodeFor("S")), ShouldContainModuloWhiteSpace, `dest.K = Kind(src.K())`)
+			cv.So(string(x.ToCapnCodeFor("S")), ShouldContainModuloWhiteSpace, `dest.SetK(uint16(src.K))`)
+			cv.So(ExtractViewString(in), ShouldContainModuloWhiteSpace, `func (v CapnSView) K() Kind { return Kind(v.src.K()) }`)
+			cv.So(ExtractTestsString(in), ShouldContainModuloWhiteSpace, `s.K = Kind(uint16(r.Int63()))`)
+		})
+	})
+
+	cv.Convey("Given a named slice type used inside another type, or a named map type", t, func() {
+		cv.Convey("then extraction should fail, naming the field and the type", func() {
+			for _, c := range []struct{ src, want string }{
+				{`type IDs []int64; type S struct { L []IDs }`, "struct 'S': field 'L' has type []IDs; bambam resolves a named slice type like IDs only when it is the whole type of a field"},
+				{`type Row []int64; type Grid []Row; type S struct { G Grid }`, "struct 'S': field 'G' has type Grid, which is []Row; bambam resolves only one level of named slice or map type"},
+				{`type Index map[string]int; type S struct { X Index }`, "struct 'S': field 'X' has type Index, and Index is a map type; bambam doesn't support Go maps"},
+				{`type Kind uint16; type S struct { L []Kind }`, "struct 'S': field 'L' has type []Kind; bambam resolves a named basic type like Kind only when it is the whole type of a field"},
+			} {
+				_, err := ExtractFromString(c.src)
+				cv.So(err == nil, cv.ShouldEqual, false)
+				cv.So(strings.Contains(err.Error(), c.want), cv.ShouldEqual, true)
+			}
+		})
+	})
+
+	cv.Convey("Given a field whose named type is declared in an input file read after its own", t, func() {
+		cv.Convey("then noting the named types of every file first should resolve it", func() {
+			dir, err := ioutil.TempDir("", "bambam-namedtypes")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+			a := filepath.Join(dir, "a.go")
+			b := filepath.Join(dir, "b.go")
+			cv.So(ioutil.WriteFile(a, []byte("package main\n\ntype S struct{ L Level; T Tags }\n"), 0644), cv.ShouldEqual, nil)
+			cv.So(ioutil.WriteFile(b, []byte("package main\n\ntype Level uint16\n\ntype Tags []string\n"), 0644), cv.ShouldEqual, nil)
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			cv.So(x.NoteNamedTypesIn([]string{a, b}), cv.ShouldEqual, nil)
+			for _, fn := range []string{a, b} {
+				_, err = x.ExtractStructsFromOneFile(nil, fn)
+				cv.So(err, cv.ShouldEqual, nil)
+			}
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct SCapn { l @0: UInt16; t @1: List(Text); }`)
+		})
+	})
+}
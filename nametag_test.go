@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func extractNameFrom(key string, src string) string {
+	x := NewExtractor()
+	defer x.Cleanup()
+	x.nameFrom = key
+	_, err := ExtractStructs("", "package main; "+src, x)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	_, err = x.WriteToSchema(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return string(buf.Bytes())
+}
+
+func TestNameFromJsonTag(t *testing.T) {
+
+	cv.Convey("Given -name-from=json and a struct with json tags", t, func() {
+		cv.Convey("then the json name, camel cased, should name the capnp field, and json:\"-\" should skip the field", func() {
+
+			ex0 := "type U struct { UserID int `json:\"user_id,omitempty\"`; Password string `json:\"-\"`; Email string }"
+			cv.So(extractNameFrom("json", ex0), ShouldStartWithModuloWhiteSpace, `struct UCapn { userId @0: Int64; email @1: Text; } `)
+		})
+
+		cv.Convey("then a capid should keep a json:\"-\" field, and a capname should win over the json name", func() {
+
+			ex0 := "type U struct { Secret string `json:\"-\" capid:\"1\"`; Name string `json:\"full_name\" capname:\"who\" capid:\"0\"` }"
+			cv.So(extractNameFrom("json", ex0), ShouldStartWithModuloWhiteSpace, `struct UCapn { who @0: Text; secret @1: Text; } `)
+		})
+	})
+
+	cv.Convey("Given a struct with json tags, but no -name-from", t, func() {
+		cv.Convey("then the json tags should be ignored, as before", func() {
+
+			ex0 := "type U struct { UserID int `json:\"user_id,omitempty\"`; Password string `json:\"-\"` }"
+			cv.So(extractNameFrom("", ex0), ShouldStartWithModuloWhiteSpace, `struct UCapn { userID @0: Int64; password @1: Text; } `)
+		})
+	})
+}
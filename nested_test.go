@@ -30,23 +30,27 @@ struct OuterCapn {
 }
 
   func (s *Inner) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	InnerGoToCapn(seg, s)
-      _, err := seg.WriteTo(w)
-      return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *Inner) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	InnerGoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
 
 
 
   func (s *Inner) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-          return err
-    	}
-    	z := ReadRootInnerCapn(capMsg)
-        InnerCapnToGo(z, s)
-     return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootInnerCapn(capMsg)
+  	InnerCapnToGo(z, s)
+  	return nil
   }
 
 
@@ -72,23 +76,27 @@ struct OuterCapn {
 
 
   func (s *Outer) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	OuterGoToCapn(seg, s)
-      _, err := seg.WriteTo(w)
-      return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *Outer) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	OuterGoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
 
 
 
   func (s *Outer) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-          return err
-    	}
-    	z := ReadRootOuterCapn(capMsg)
-        OuterCapnToGo(z, s)
-     return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootOuterCapn(capMsg)
+  	OuterCapnToGo(z, s)
+  	return nil
   }
 
 
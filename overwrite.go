@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backupSuffix ends the name of every -OVERWRITE backup, so that the
+// go tool doesn't build a backup of x.go as a package of its own; for
+// a package directory, the backups are inside the package.
+const backupSuffix = ".bak"
+
+// backupPath is where -OVERWRITE backs up the source file fn, under
+// bkDir: at fn's path relative to the working directory, so that
+// a/x.go and b/x.go don't collide, plus backupSuffix. A file outside
+// the working directory keeps its whole absolute path under bkDir.
+func backupPath(bkDir, fn string) string {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return filepath.Join(bkDir, fn) + backupSuffix
+	}
+	if wd, err := os.Getwd(); err == nil {
+		rel, err := filepath.Rel(wd, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.Join(bkDir, rel) + backupSuffix
+		}
+	}
+	vol := filepath.VolumeName(abs)
+	return filepath.Join(bkDir, strings.TrimSuffix(vol, ":"), strings.TrimPrefix(abs, vol)) + backupSuffix
+}
+
+// writeFileAtomic replaces fn with data, mode perm. It writes a
+// temporary file in fn's directory, syncs it, and renames it over fn,
+// so that fn holds either its old contents or data, never a mix, even
+// if bambam or the machine dies halfway.
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(fn)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fn)+".bambam-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fn); err != nil {
+		return err
+	}
+
+	// make the rename itself durable, where the OS lets us.
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupSources copies each source file to backupPath(bkDir, file),
+// before -OVERWRITE replaces them.
+func (x *Extractor) backupSources(bkDir string) error {
+	for _, s := range x.srcFiles {
+		if s.filename == "" {
+			continue
+		}
+		fi, err := os.Stat(s.filename)
+		if err != nil {
+			return fmt.Errorf("backing up '%s': %s", s.filename, err)
+		}
+		cur, err := ioutil.ReadFile(s.filename)
+		if err != nil {
+			return fmt.Errorf("backing up '%s': %s", s.filename, err)
+		}
+		bk := backupPath(bkDir, s.filename)
+		err = os.MkdirAll(filepath.Dir(bk), 0755)
+		if err == nil {
+			err = writeFileAtomic(bk, cur, fi.Mode().Perm())
+		}
+		if err != nil {
+			return fmt.Errorf("backing up '%s' to '%s': %s", s.filename, bk, err)
+		}
+	}
+	return nil
+}
+
+// replaceSource writes tagged over s's file, keeping its mode, if the
+// file still holds the text bambam read from it.
+func replaceSource(s *SrcFile, tagged []byte) error {
+	fi, err := os.Stat(s.filename)
+	if err != nil {
+		return err
+	}
+	cur, err := ioutil.ReadFile(s.filename)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cur, s.src) {
+		return fmt.Errorf("'%s' changed while bambam was reading it; not overwriting it", s.filename)
+	}
+	if bytes.Equal(cur, tagged) {
+		return nil
+	}
+	return writeFileAtomic(s.filename, tagged, fi.Mode().Perm())
+}
+
+// WriteCapidDiff writes, for -diff, the unified diff of the capid tags
+// that bambam would add to each source file, and changes nothing.
+// WriteToSchema must have numbered the fields.
+func (x *Extractor) WriteCapidDiff(w io.Writer) error {
+	for _, s := range x.srcFiles {
+		if s.filename == "" {
+			continue
+		}
+		tagged, err := x.CapidTaggedSource(s)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, UnifiedDiff(s.filename, s.filename, s.src, tagged))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
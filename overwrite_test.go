@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestOverwriteBacksUpAndReplacesAtomically(t *testing.T) {
+
+	cv.Convey("Given -OVERWRITE on source files in nested directories", t, func() {
+		in := NewSimpleTempDir("overwrite_")
+		defer os.RemoveAll(in)
+		orig := "package main\n\ntype A struct {\n\tX int // x\n}\n"
+		aFn := filepath.Join(in, "sub", "a.go")
+		cv.So(os.MkdirAll(filepath.Dir(aFn), 0755), cv.ShouldEqual, nil)
+		cv.So(ioutil.WriteFile(aFn, []byte(orig), 0600), cv.ShouldEqual, nil)
+
+		x := NewExtractor()
+		defer x.Cleanup()
+		x.overwrite = true
+		_, err := x.ExtractStructsFromOneFile(nil, aFn)
+		cv.So(err, cv.ShouldEqual, nil)
+		_, err = x.WriteToSchema(ioutil.Discard)
+		cv.So(err, cv.ShouldEqual, nil)
+
+		cv.Convey("then -diff should show just the tag, and change nothing", func() {
+			var diff strings.Builder
+			cv.So(x.WriteCapidDiff(&diff), cv.ShouldEqual, nil)
+			cv.So(diff.String(), cv.ShouldEqual, "--- "+aFn+"\n+++ "+aFn+"\n"+
+				"@@ -1,5 +1,5 @@\n"+
+				" package main\n"+
+				" \n"+
+				" type A struct {\n"+
+				"-\tX int // x\n"+
+				"+\tX int `capid:\"0\"` // x\n"+
+				" }\n")
+			now, err := ioutil.ReadFile(aFn)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(now), cv.ShouldEqual, orig)
+		})
+
+		cv.Convey("then the original should be backed up at its own relative path, and replaced keeping its mode", func() {
+			cv.So(x.CopySourceFilesAddCapidTag(), cv.ShouldEqual, nil)
+
+			bk, err := ioutil.ReadFile(filepath.Join(x.compileDir.DirPath, "bk", in, "sub", "a.go.bak"))
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(bk), cv.ShouldEqual, orig)
+
+			now, err := ioutil.ReadFile(aFn)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(now), cv.ShouldEqual, "package main\n\ntype A struct {\n\tX int `capid:\"0\"` // x\n}\n")
+			fi, err := os.Stat(aFn)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(fi.Mode().Perm(), cv.ShouldEqual, os.FileMode(0600))
+
+			// no temporary files left behind
+			left, err := ioutil.ReadDir(filepath.Dir(aFn))
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(left), cv.ShouldEqual, 1)
+		})
+
+		cv.Convey("then a file changed since bambam read it should be left alone, with an error", func() {
+			changed := orig + "\n// edited meanwhile\n"
+			cv.So(ioutil.WriteFile(aFn, []byte(changed), 0600), cv.ShouldEqual, nil)
+
+			err := x.CopySourceFilesAddCapidTag()
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "changed while bambam was reading it"), cv.ShouldEqual, true)
+			now, err := ioutil.ReadFile(aFn)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(now), cv.ShouldEqual, changed)
+		})
+
+	})
+
+	cv.Convey("Given a source file outside the working directory", t, func() {
+		cv.Convey("then its backup should keep its absolute path under bk", func() {
+			abs, err := filepath.Abs(filepath.Join("..", "elsewhere", "c.go"))
+			cv.So(err, cv.ShouldEqual, nil)
+			vol := filepath.VolumeName(abs)
+			cv.So(backupPath("bk", abs), cv.ShouldEqual, filepath.Join("bk", strings.TrimSuffix(vol, ":"), strings.TrimPrefix(abs, vol))+".bak")
+			cv.So(backupPath("bk", filepath.Join("pkg", "c.go")), cv.ShouldEqual, filepath.Join("bk", "pkg", "c.go.bak"))
+		})
+	})
+
+	cv.Convey("Given -OVERWRITE on a package directory, which is also the output directory", t, func() {
+		cv.Convey("then the backups should not leave any .go file beside the package's own", func() {
+			pkgDir := NewSimpleTempDir("overwrite_pkg_")
+			defer os.RemoveAll(pkgDir)
+			orig := "package shapes\n\ntype Rect struct {\n\tW int\n}\n"
+			cv.So(ioutil.WriteFile(filepath.Join(pkgDir, "shapes.go"), []byte(orig), 0644), cv.ShouldEqual, nil)
+
+			// as MainArgs does for a package directory
+			_, files, err := PackageGoFiles(pkgDir)
+			cv.So(err, cv.ShouldEqual, nil)
+			x := NewExtractor()
+			x.compileDir.Cleanup()
+			x.compileDir.DirPath = pkgDir
+			x.overwrite = true
+			for _, fn := range files {
+				_, err = x.ExtractStructsFromOneFile(nil, fn)
+				cv.So(err, cv.ShouldEqual, nil)
+			}
+			for _, sf := range x.srcFiles {
+				sf.outName = filepath.Base(sf.filename)
+			}
+			_, err = x.WriteToSchema(ioutil.Discard)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(x.CopySourceFilesAddCapidTag(), cv.ShouldEqual, nil)
+
+			var goFiles []string
+			err = filepath.Walk(pkgDir, func(path string, fi os.FileInfo, err error) error {
+				if err == nil && strings.HasSuffix(path, ".go") {
+					goFiles = append(goFiles, path)
+				}
+				return err
+			})
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(strings.Join(goFiles, " "), cv.ShouldEqual, filepath.Join(pkgDir, "shapes.go"))
+
+			bk, err := ioutil.ReadFile(backupPath(filepath.Join(pkgDir, "bk"), filepath.Join(pkgDir, "shapes.go")))
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(string(bk), cv.ShouldEqual, orig)
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// bambamOutputFiles are the Go files that bambam, or capnpc-go on the
+// schema bambam wrote, put in the output directory.
+var bambamOutputFiles = map[string]bool{
+	"translateCapn.go":      true,
+	"translateCapn_test.go": true,
+	"schema.capnp.go":       true,
+}
+
+// regexGenerated is the standard marker of generated Go, see
+// https://golang.org/s/generatedcode
+var regexGenerated = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// PackageGoFiles lists the .go files of the package in dir that
+// bambam should read: those that build in the current environment,
+// minus _test.go files, generated files, and bambam's own output.
+func PackageGoFiles(dir string) (pkgName string, files []string, err error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, fn := range pkg.GoFiles {
+		if bambamOutputFiles[fn] {
+			continue
+		}
+		path := filepath.Join(dir, fn)
+		generated, err := IsGeneratedGoFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if generated {
+			VPrintf("PackageGoFiles: skipping generated file '%s'\n", path)
+			continue
+		}
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("no non-generated, non-test .go files in '%s'", dir)
+	}
+	return pkg.Name, files, nil
+}
+
+// IsGeneratedGoFile reports whether fn carries a
+// "// Code generated ... DO NOT EDIT." line before its package clause.
+func IsGeneratedGoFile(fn string) (bool, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if regexGenerated.MatchString(line) {
+			return true, nil
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// samePath reports whether a and b name the same file, comparing
+// absolute paths.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestPackageDirInput(t *testing.T) {
+
+	cv.Convey("Given a package directory with a source file, a test file, a generated file and bambam's own output", t, func() {
+		cv.Convey("then PackageGoFiles should return the package name and only the source file", func() {
+
+			dir, err := ioutil.TempDir("", "bambam-pkgdir")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			files := map[string]string{
+				"shapes.go":        "package shapes\n\n//go:generate bambam\n\ntype Rect struct{ W, H int }\n",
+				"shapes_test.go":   "package shapes\n\ntype testOnly struct{ X int }\n",
+				"stringer.go":      "// Code generated by \"stringer -type=Kind\"; DO NOT EDIT.\n\npackage shapes\n\ntype Kind int\n",
+				"translateCapn.go": "package shapes\n",
+			}
+			for fn, src := range files {
+				cv.So(ioutil.WriteFile(filepath.Join(dir, fn), []byte(src), 0644), cv.ShouldEqual, nil)
+			}
+
+			pkgName, goFiles, err := PackageGoFiles(dir)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(pkgName, cv.ShouldEqual, "shapes")
+			cv.So(strings.Join(goFiles, " "), cv.ShouldEqual, filepath.Join(dir, "shapes.go"))
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	cv "github.com/glycerine/goconvey/convey"
@@ -22,6 +23,31 @@ type s1 struct {
 	})
 }
 
+func TestNilPointerFieldSurvivesRoundTrip(t *testing.T) {
+
+	cv.Convey("Given a struct with a pointer to a struct", t, func() {
+		cv.Convey("then a nil pointer should be skipped on save, and a null capnp pointer should load as nil", func() {
+
+			ex0 := `
+type Big struct { A int }
+type s1 struct {
+  MyBig *Big
+}`
+			out0 := ExtractString2String(ex0)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+	if capn.Object(src.MyBig()).Type() == capn.TypeNull {
+		dest.MyBig = nil
+	} else {
+		dest.MyBig = BigCapnToGo(src.MyBig(), nil)
+	}`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+	if src.MyBig != nil {
+		dest.SetMyBig(BigGoToCapn(seg, src.MyBig))
+	}`)
+		})
+	})
+}
+
 func TestPointerInSliceInStruct(t *testing.T) {
 
 	cv.Convey("Given a struct that contains a slice of pointers", t, func() {
@@ -38,24 +64,28 @@ type s1 struct {
 
 			expect0 := `
 struct BigCapn { a @0: Int64; }
-struct S1Capn { ptrs @0: List(BigCapn); } 
+struct S1Capn { ptrs @0: List(BigCapn); }
 
     func (s *Big) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *Big) SaveWith(seg *capn.Segment, w io.Writer) error {
     	BigGoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
       
     func (s *Big) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootBigCapn(capMsg)
-        BigCapnToGo(z, s)
-        return nil
+    	BigCapnToGo(z, s)
+    	return nil
     }
 
 func BigCapnToGo(src BigCapn, dest *Big) *Big {
@@ -76,10 +106,15 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
 
   
     func (s *s1) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *s1) SaveWith(seg *capn.Segment, w io.Writer) error {
     	s1GoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
    
   
@@ -87,12 +122,11 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
     func (s *s1) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootS1Capn(capMsg)
-        S1CapnToGo(z, s)
-        return nil
+    	S1CapnToGo(z, s)
+    	return nil
     }
 
 func S1CapnToGo(src S1Capn, dest *s1) *s1 {
@@ -115,13 +149,15 @@ func S1CapnToGo(src S1Capn, dest *s1) *s1 {
 func s1GoToCapn(seg *capn.Segment, src *s1) S1Capn {
 	dest := AutoNewS1Capn(seg)
 
-	// Ptrs -> BigCapn (go slice to capn list)
+	// Ptrs -> BigCapn (go slice to capn list); a nil element is saved as a zero Big
 	if len(src.Ptrs) > 0 {
 		typedList := NewBigCapnList(seg, len(src.Ptrs))
 		plist := capn.PointerList(typedList)
 		i := 0
 		for _, ele := range src.Ptrs {
-			plist.Set(i, capn.Object(BigGoToCapn(seg, ele)))
+			if ele != nil {
+				plist.Set(i, capn.Object(BigGoToCapn(seg, ele)))
+			}
 			i++
 		}
 		dest.SetPtrs(typedList)
@@ -153,14 +189,19 @@ type s1 struct {
 `
 			expect0 := `
 struct BigCapn { a  @0:   Int64; b  @1:   Text; c  @2:   List(Text); } 
-struct S1Capn { ptrs      @0:   List(BigCapn); straight  @1:   List(BigCapn); } 
+struct S1Capn { ptrs      @0:   List(BigCapn); straight  @1:   List(BigCapn); }
 
   
     func (s *Big) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *Big) SaveWith(seg *capn.Segment, w io.Writer) error {
     	BigGoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
    
   
@@ -168,12 +209,11 @@ struct S1Capn { ptrs      @0:   List(BigCapn); straight  @1:   List(BigCapn); }
     func (s *Big) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootBigCapn(capMsg)
-        BigCapnToGo(z, s)
-        return nil
+    	BigCapnToGo(z, s)
+    	return nil
     }
   
 func BigCapnToGo(src BigCapn, dest *Big) *Big {
@@ -203,10 +243,15 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
 
   
     func (s *s1) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *s1) SaveWith(seg *capn.Segment, w io.Writer) error {
     	s1GoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
    
   
@@ -214,12 +259,11 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
     func (s *s1) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootS1Capn(capMsg)
-        S1CapnToGo(z, s)
-        return nil
+    	S1CapnToGo(z, s)
+    	return nil
     }
 
 func S1CapnToGo(src S1Capn, dest *s1) *s1 {
@@ -249,13 +293,15 @@ func S1CapnToGo(src S1Capn, dest *s1) *s1 {
 func s1GoToCapn(seg *capn.Segment, src *s1) S1Capn {
 	dest := AutoNewS1Capn(seg)
 
-	// Ptrs -> BigCapn (go slice to capn list)
+	// Ptrs -> BigCapn (go slice to capn list); a nil element is saved as a zero Big
 	if len(src.Ptrs) > 0 {
 		typedList := NewBigCapnList(seg, len(src.Ptrs))
 		plist := capn.PointerList(typedList)
 		i := 0
 		for _, ele := range src.Ptrs {
-			plist.Set(i, capn.Object(BigGoToCapn(seg, ele)))
+			if ele != nil {
+				plist.Set(i, capn.Object(BigGoToCapn(seg, ele)))
+			}
 			i++
 		}
 		dest.SetPtrs(typedList)
@@ -280,3 +326,119 @@ func s1GoToCapn(seg *capn.Segment, src *s1) S1Capn {
 		})
 	})
 }
+
+func TestSliceOfPointersKeepsNilElements(t *testing.T) {
+
+	cv.Convey("Given a struct with a []*Big", t, func() {
+		cv.Convey("then the list view should read each element as a Big, since a nil one was saved as a zero Big", func() {
+			in0 := "type Big struct { A int }\ntype S struct { Ptrs []*Big }"
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `
+type CapnSlicePtrBigView struct {
+	src BigCapn_List
+}`)
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `
+func (v CapnSlicePtrBigView) At(i int) CapnBigView {
+	return NewCapnBigView(v.src.At(i))
+}`)
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `s[i] = BigCapnToGo(v.src.At(i), nil)`)
+		})
+	})
+
+	cv.Convey("Given a []*Big tagged capnil:\"keep\"", t, func() {
+		in0 := "type Big struct { A int }\ntype S struct { Ptrs []*Big `capnil:\"keep\"` }"
+
+		cv.Convey("then the schema should hold each element in a PtrBigCapn, whose ptr is null for a nil one", func() {
+			cv.So(ExtractString2String(in0), ShouldStartWithModuloWhiteSpace, `struct BigCapn { a @0: Int64; } struct SCapn { ptrs @0: List(PtrBigCapn); }
+# PtrBigCapn holds one element of a Go []*Big; a nil element has a null ptr.
+struct PtrBigCapn { ptr @0: BigCapn; }`)
+		})
+
+		cv.Convey("then the translators should leave a nil element's ptr null, and load a null ptr as nil", func() {
+			cv.So(ExtractGoToCapnCode(in0, "S"), ShouldContainModuloWhiteSpace, `
+	// Ptrs -> PtrBigCapn (go slice to capn list); a nil element keeps a null ptr
+	if len(src.Ptrs) > 0 {
+		typedList := NewPtrBigCapnList(seg, len(src.Ptrs))
+		for i, ele := range src.Ptrs {
+			if ele != nil {
+				typedList.At(i).SetPtr(BigGoToCapn(seg, ele))
+			}
+		}
+		dest.SetPtrs(typedList)
+	}`)
+			cv.So(ExtractCapnToGoCode(in0, "S"), ShouldContainModuloWhiteSpace, `
+	for i := 0; i < n; i++ {
+		if capn.Object(src.Ptrs().At(i).Ptr()).Type() == capn.TypeNull {
+			continue
+		}
+		dest.Ptrs[i] = BigCapnToGo(src.Ptrs().At(i).Ptr(), nil)
+	}`)
+		})
+
+		cv.Convey("then the list view should read each element through its PtrBigCapn, leaving a null one nil", func() {
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `
+type CapnSlicePtrBigOrNilView struct {
+	src PtrBigCapn_List
+}`)
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `
+		if capn.Object(v.src.At(i).Ptr()).Type() != capn.TypeNull {
+			s[i] = BigCapnToGo(v.src.At(i).Ptr(), nil)
+		}`)
+			cv.So(ExtractViewString(in0), ShouldContainModuloWhiteSpace, `
+// At returns false for a nil element.
+func (v CapnSlicePtrBigOrNilView) At(i int) (CapnBigView, bool) {
+	if capn.Object(v.src.At(i).Ptr()).Type() == capn.TypeNull {
+		return CapnBigView{}, false
+	}
+	return NewCapnBigView(v.src.At(i).Ptr()), true
+}`)
+		})
+
+		cv.Convey("then the -gentests populator should make some elements nil", func() {
+			cv.So(ExtractTestsString(in0), ShouldContainModuloWhiteSpace, `s.Ptrs = bambamRandSlicePtrBigOrNil(r, depth+1)`)
+			cv.So(ExtractTestsString(in0), ShouldContainModuloWhiteSpace, `v[i] = bambamRandPtrBig(r, depth+1)`)
+		})
+	})
+
+	cv.Convey("Given a misused capnil tag", t, func() {
+		cv.Convey("then a capnil:\"keep\" on anything but a []*T should be refused", func() {
+			_, err := ExtractFromString("type Big struct { A int }\ntype S struct { PP [][]*Big `capnil:\"keep\"` }")
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.HasPrefix(err.Error(), `struct 'S': field 'PP': capnil:"keep" applies only to a field whose whole type is a slice of pointers to structs, like []*T, not to [][]*Big`), cv.ShouldEqual, true)
+		})
+
+		cv.Convey("then a capnil value other than keep should be refused", func() {
+			_, err := ExtractFromString("type Big struct { A int }\ntype S struct { Ptrs []*Big `capnil:\"null\"` }")
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), `problem in capnil tag 'null' on field 'Ptrs' in struct 'S': the only capnil value is "keep"`), cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given a [][]*Big", t, func() {
+		cv.Convey("then it should be a List(List(BigCapn)), converted a []*Big at a time, with a nil element saved as a zero Big", func() {
+			in0 := "type Big struct { A int }\ntype S struct { PP [][]*Big }"
+			out0 := ExtractString2String(in0)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `struct SCapn { pP @0: List(List(BigCapn)); }`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `dest.PP[i] = BigCapnListToSlicePtrBig(BigCapn_List(src.PP().At(i)))`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `plist.Set(i, capn.Object(SlicePtrBigToBigCapnList(seg, ele)))`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+// SlicePtrBigToBigCapnList saves a nil element as a zero Big.
+func SlicePtrBigToBigCapnList(seg *capn.Segment, m []*Big) BigCapn_List {
+	lst := NewBigCapnList(seg, len(m))
+	for i := range m {
+		if m[i] != nil {
+			lst.Set(i, BigGoToCapn(seg, m[i]))
+		}
+	}
+	return lst
+}`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func BigCapnListToSlicePtrBig(p BigCapn_List) []*Big {
+	v := make([]*Big, p.Len())
+	for i := range v {
+		v[i] = BigCapnToGo(p.At(i), nil)
+	}
+	return v
+}`)
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A []*T field is a List(TCapn). A capnp list of structs holds its
+// elements inline, so none of them can be null: a nil element is saved
+// as a zero T, and loads back as &T{}.
+//
+// A []*T field tagged capnil:"keep" keeps its nil elements instead.
+// Each element goes in a struct of one pointer,
+//
+//	struct PtrTCapn { ptr @0: TCapn; }
+//
+// whose ptr is null for a nil element, and the field is a
+// List(PtrTCapn). That is a different wire type, so the tag belongs on
+// new fields, not on ones that already have saved data.
+
+// ptrWrapperName names the struct holding one element of a []*T,
+// given T's capnp name: PtrBigCapn for BigCapn.
+func ptrWrapperName(capBase string) string { return "Ptr" + capBase }
+
+// capnilFromTag returns the value of the capnil key in the struct tag
+// literal tagLit, e.g. keep for `capnil:"keep"`.
+func capnilFromTag(tagLit string) string {
+	unquoted, err := strconv.Unquote(tagLit)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(unquoted).Get("capnil")
+}
+
+// isPtrSlice reports whether goTypeSeq is a []*T, with T a struct.
+func isPtrSlice(goTypeSeq []string) bool {
+	return len(goTypeSeq) == 3 && goTypeSeq[0] == "[]" && isStructPtr(goTypeSeq[1:])
+}
+
+// ptrListType returns the capnp type of a field of Go type goTypeSeq,
+// whose capnp type would otherwise be capType. With keepNil, from a
+// capnil:"keep" tag, that is List(PtrTCapn) for a []*T, noting PtrTCapn
+// in x.ptrWrappers; keepNil on any other type is an error. Without it,
+// capType is returned unchanged.
+func (x *Extractor) ptrListType(goTypeSeq []string, capTypeSeq []string, capType string, keepNil bool) (string, error) {
+	if !keepNil {
+		return capType, nil
+	}
+	if !isPtrSlice(goTypeSeq) {
+		return "", fmt.Errorf(`capnil:"keep" applies only to a field whose whole type is a slice of pointers to structs, like []*T, not to %s`, strings.Join(goTypeSeq, ""))
+	}
+	capBase := last(capTypeSeq)
+	wrapper := ptrWrapperName(capBase)
+	x.ptrWrappers[wrapper] = capBase
+	return "List(" + wrapper + ")", nil
+}
+
+// PtrWrapper names the struct holding each element of a []*T field
+// tagged capnil:"keep", PtrBigCapn for []*Big, or is empty for other
+// fields.
+func (f *Field) PtrWrapper() string {
+	if !f.keepNil {
+		return ""
+	}
+	return ptrWrapperName(f.CapBaseType())
+}
+
+// writePtrWrappers writes the schema for the PtrTCapn structs that the
+// []*T fields tagged capnil:"keep" use, in name order.
+func (x *Extractor) writePtrWrappers(w io.Writer) (n int64, err error) {
+	names := make([]string, 0, len(x.ptrWrappers))
+	for name := range x.ptrWrappers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var m int
+	for _, name := range names {
+		if goName, taken := x.capType2goType[name]; taken {
+			return n, fmt.Errorf("struct '%s' has the capnp name '%s', which bambam needs for the elements of a []*%s; rename it with a capname comment", goName, name, x.capType2goType[x.ptrWrappers[name]])
+		}
+		elem := x.ptrWrappers[name]
+		doc := []string{fmt.Sprintf("%s holds one element of a Go []*%s; a nil element has a null ptr.", name, x.capType2goType[elem])}
+		m, err = fmt.Fprintf(w, "%s%sstruct %s { %s%sptr @0: %s; %s} %s",
+			x.fieldSuffix, schemaComment("", doc), name, x.fieldSuffix, x.fieldPrefix, elem, x.fieldSuffix, x.fieldSuffix)
+		n += int64(m)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package main
+
+// MarkRecursive sets Struct.recursive on every struct that can reach
+// itself through its fields, directly (type Node struct { Next *Node })
+// or by way of other structs. XCapnToGo follows such structs as deep
+// as the message goes, so -max-load-depth bounds them.
+func (x *Extractor) MarkRecursive() {
+	for _, s := range x.srs {
+		s.recursive = x.reaches(s, s.goName, make(map[string]bool))
+	}
+}
+
+// reaches reports whether a struct named goal is reachable from the fields of s.
+func (x *Extractor) reaches(s *Struct, goal string, seen map[string]bool) bool {
+	for _, f := range s.fld {
+		t := x.srs[last(fieldGoTypeSeq(f))]
+		if t == nil {
+			continue
+		}
+		if t.goName == goal {
+			return true
+		}
+		if seen[t.goName] {
+			continue
+		}
+		seen[t.goName] = true
+		if x.reaches(t, goal, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadMayFail reports whether XCapnToGo of s can fail under
+// -max-load-depth, because s, or a struct reachable from its fields,
+// is recursive and so depth-limited. It needs only x.srs, not
+// MarkRecursive, so the views can ask too.
+func (x *Extractor) loadMayFail(s *Struct) bool {
+	return x.maxLoadDepth > 0 && x.reachesRecursive(s, make(map[string]bool))
+}
+
+func (x *Extractor) reachesRecursive(s *Struct, seen map[string]bool) bool {
+	if seen[s.goName] {
+		return false
+	}
+	seen[s.goName] = true
+	if x.reaches(s, s.goName, make(map[string]bool)) {
+		return true
+	}
+	for _, f := range s.fld {
+		if t := x.srs[last(fieldGoTypeSeq(f))]; t != nil && x.reachesRecursive(t, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadDepthLimit is how many structs deep XCapnToGo follows s before
+// giving up, or 0 for no limit. Only recursive structs are limited;
+// the depth of the rest is bounded by their types.
+func (s *Struct) LoadDepthLimit() int { return s.loadDepthLimit }
+
+// LoadDepth reports whether the struct f refers to is converted by
+// its depth-counting XCapnToGoDepth, one level deeper.
+func (f *Field) LoadDepth() bool { return f.loadDepth }
+
+// LoadMayFail reports whether XCapnToGo of s returns an error too, as
+// it does when s can reach a struct limited by -max-load-depth.
+func (s *Struct) LoadMayFail() bool { return s.loadMayFail }
+
+// ToGoMayFail reports whether the CapnToGo of the struct f refers to
+// returns an error, as a struct's does when LoadMayFail.
+func (f *Field) ToGoMayFail() bool { return f.toGoMayFail }
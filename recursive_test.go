@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+const recursiveSrc = `
+type Node struct {
+	Val      int
+	Children []*Node
+	Next     *Node
+}
+type Expr struct { Op string; Args []Term }
+type Term struct { Lit int; Sub *Expr; Subs [][]Expr }
+type Holder struct { Root *Node; Label string }
+`
+
+// recursiveExtract extracts recursiveSrc with -max-load-depth set to
+// maxLoadDepth, and generates its translators.
+func recursiveExtract(maxLoadDepth int) *Extractor {
+	x := NewExtractor()
+	x.maxLoadDepth = maxLoadDepth
+	_, err := ExtractStructs("", "package main; "+recursiveSrc, x)
+	if err != nil {
+		panic(err)
+	}
+	x.GenerateTranslators()
+	return x
+}
+
+func TestRecursiveStructs(t *testing.T) {
+
+	cv.Convey("Given self- and mutually recursive structs", t, func() {
+		cv.Convey("then MarkRecursive should flag the structs in a cycle, and only those", func() {
+			x := recursiveExtract(0)
+			defer x.Cleanup()
+			cv.So(x.srs["Node"].recursive, cv.ShouldEqual, true)
+			cv.So(x.srs["Expr"].recursive, cv.ShouldEqual, true)
+			cv.So(x.srs["Term"].recursive, cv.ShouldEqual, true)
+			cv.So(x.srs["Holder"].recursive, cv.ShouldEqual, false)
+		})
+
+		cv.Convey("then the schema should be valid, and by default the translators should recurse without a depth count, saving nil children as zero Nodes", func() {
+			x := recursiveExtract(0)
+			defer x.Cleanup()
+			var buf bytes.Buffer
+			_, err := x.WriteToSchema(&buf)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(ValidateCapnpFile("recursive", buf.Bytes())), cv.ShouldEqual, 0)
+			cv.So(buf.String(), ShouldContainModuloWhiteSpace, `struct NodeCapn { val @0: Int64; children @1: List(NodeCapn); next @2: NodeCapn; }`)
+			cv.So(string(x.ToGoCodeFor("Node")), ShouldContainModuloWhiteSpace, `dest.Next = NodeCapnToGo(src.Next(), nil)`)
+			cv.So(string(x.ToGoCodeFor("Node")), ShouldContainModuloWhiteSpace, `
+		dest.Children[i] = NodeCapnToGo(src.Children().At(i), nil)`)
+			cv.So(string(x.ToCapnCodeFor("Node")), ShouldContainModuloWhiteSpace, `
+			if ele != nil {
+				plist.Set(i, capn.Object(NodeGoToCapn(seg, ele)))
+			}`)
+		})
+	})
+
+	cv.Convey("Given -max-load-depth=5", t, func() {
+		cv.Convey("then recursive structs should count their depth and return an error past 5, checked at each level", func() {
+			x := recursiveExtract(5)
+			defer x.Cleanup()
+			node := string(x.ToGoCodeFor("Node"))
+			cv.So(node, ShouldContainModuloWhiteSpace, `
+func NodeCapnToGo(src NodeCapn, dest *Node) (*Node, error) {
+	return NodeCapnToGoDepth(src, dest, 0)
+}`)
+			cv.So(node, ShouldContainModuloWhiteSpace, `
+func NodeCapnToGoDepth(src NodeCapn, dest *Node, depth int) (*Node, error) {
+	if depth > 5 {
+		return nil, fmt.Errorf("NodeCapn nested more than 5 deep")
+	}`)
+			cv.So(node, ShouldContainModuloWhiteSpace, `
+		v, err := NodeCapnToGoDepth(src.Children().At(i), nil, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		dest.Children[i] = v`)
+			cv.So(node, ShouldContainModuloWhiteSpace, `
+		v, err := NodeCapnToGoDepth(src.Next(), nil, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		dest.Next = v`)
+			cv.So(string(x.ToGoCodeFor("Term")), ShouldContainModuloWhiteSpace, `
+				if _, err := ExprCapnToGoDepth(p.At(j), &v[j], depth+1); err != nil {
+					return nil, err
+				}`)
+			cv.So(string(x.ToGoCodeFor("Expr")), ShouldContainModuloWhiteSpace, `
+		if _, err := TermCapnToGoDepth(src.Args().At(i), &dest.Args[i], depth+1); err != nil {
+			return nil, err
+		}`)
+		})
+
+		cv.Convey("then a struct holding a recursive one should pass the error up, through Load and its view, while others are unchanged", func() {
+			x := recursiveExtract(5)
+			defer x.Cleanup()
+			cv.So(x.srs["Holder"].recursive, cv.ShouldEqual, false)
+			cv.So(string(x.ToGoCodeFor("Holder")), ShouldContainModuloWhiteSpace, `
+func HolderCapnToGo(src HolderCapn, dest *Holder) (*Holder, error) {`)
+			cv.So(string(x.ToGoCodeFor("Holder")), ShouldContainModuloWhiteSpace, `
+		v, err := NodeCapnToGo(src.Root(), nil)
+		if err != nil {
+			return nil, err
+		}
+		dest.Root = v`)
+			cv.So(string(x.LoadCode["Holder"]), ShouldContainModuloWhiteSpace, `
+	if _, err := HolderCapnToGo(z, s); err != nil {
+		return fmt.Errorf("Holder.Load: %s", err)
+	}`)
+			var views bytes.Buffer
+			_, err := x.WriteToViews(&views)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(views.String(), ShouldContainModuloWhiteSpace, `
+func (v CapnHolderView) ToGo() (*Holder, error) {
+	return HolderCapnToGo(v.src, nil)
+}`)
+			cv.So(views.String(), ShouldContainModuloWhiteSpace, `
+func (v CapnSliceExprView) ToSlice() ([]Expr, error) {
+	s := make([]Expr, v.Len())
+	for i := range s {
+		if _, err := ExprCapnToGo(v.src.At(i), &s[i]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}`)
+
+			y := recursiveExtract(0)
+			defer y.Cleanup()
+			cv.So(string(y.ToGoCodeFor("Holder")), ShouldContainModuloWhiteSpace, `
+func HolderCapnToGo(src HolderCapn, dest *Holder) *Holder {`)
+		})
+
+		cv.Convey("then the generated round-trip tests should not nest random values deeper than Load allows", func() {
+			x := recursiveExtract(5)
+			defer x.Cleanup()
+			var buf bytes.Buffer
+			_, err := x.WriteToTests(&buf)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(buf.String(), ShouldContainModuloWhiteSpace, `const bambamMaxDepth = 5`)
+			cv.So(buf.String(), ShouldContainModuloWhiteSpace, `
+func bambamRandPtrNode(r *rand.Rand, depth int) *Node {
+	if depth > bambamMaxDepth || r.Intn(4) == 0 {
+		return nil
+	}
+	return bambamRandomNode(r, depth)
+}`)
+		})
+	})
+}
@@ -20,6 +20,8 @@ func Test015WriteRead_StructWithinStruct(t *testing.T) {
 	}
 
 	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, "rw2.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
 
 	cv.Convey("Given bambam generated go bindings: with a struct within a struct", t, func() {
 		cv.Convey("then we should be able to write to disk, and read back the same structure", func() {
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test020WriteRead_NilElementOfSliceOfPointers(t *testing.T) {
+
+	tdir := NewTempDir()
+	// comment the defer out to debug any rw test failures.
+	defer tdir.Cleanup()
+
+	err := exec.Command("cp", "rw4.go.txt", tdir.DirPath+"/rw4.go").Run()
+	if err != nil {
+		panic(err)
+	}
+
+	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, "rw4.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
+
+	cv.Convey("Given bambam generated go bindings: with a slice of struct pointers holding a nil", t, func() {
+		cv.Convey("then we should be able to write to disk, and read back a capnil:\"keep\" nil element as nil, and the others as zero structs", func() {
+			cv.So(err, cv.ShouldEqual, nil)
+
+			tdir.MoveTo()
+
+			err = exec.Command("capnpc", "-ogo", "schema.capnp").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			err = exec.Command("go", "build").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			// run it
+			err = exec.Command("./" + tdir.DirPath).Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+		})
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test022WriteRead_CapdefaultForOldMessages(t *testing.T) {
+
+	tdir := NewTempDir()
+	// comment the defer out to debug any rw test failures.
+	defer tdir.Cleanup()
+
+	err := exec.Command("cp", "rw6.go.txt", tdir.DirPath+"/rw6.go").Run()
+	if err != nil {
+		panic(err)
+	}
+
+	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, "rw6.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
+
+	cv.Convey("Given bambam generated go bindings: with capdefault fields, and a message saved before they existed", t, func() {
+		cv.Convey("then loading the old message should give the defaults, and zero values should load as zero, Text included", func() {
+			cv.So(err, cv.ShouldEqual, nil)
+
+			tdir.MoveTo()
+
+			err = exec.Command("capnpc", "-ogo", "schema.capnp").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			err = exec.Command("go", "build").Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+			// run it
+			err = exec.Command("./" + tdir.DirPath).Run()
+			cv.So(err, cv.ShouldEqual, nil)
+
+		})
+	})
+}
@@ -22,6 +22,8 @@ func Test003WriteReadThroughGeneratedTranslationCode(t *testing.T) {
 	}
 
 	MainArgs([]string{os.Args[0], "-o", tdir.DirPath, "rw.go.txt"})
+	// MainArgs has validated the schema; compiling it needs capnpc.
+	skipWithoutTools(t, "capnpc")
 
 	cv.Convey("Given bambam generated go bindings, \n"+
 		"        then we should be able to write to disk, and read back the same structure", t, func() {
@@ -22,21 +22,25 @@ struct RWTestCapn {
 }
 
 func (s *RWTest) Save(w io.Writer) error {
-	seg := capn.NewBuffer(nil)
+	return s.SaveWith(capn.NewBuffer(nil), w)
+}
+
+// SaveWith is Save, but serializes into seg instead of a fresh buffer.
+// A CapnEncoder hands out reusable segments for this.
+func (s *RWTest) SaveWith(seg *capn.Segment, w io.Writer) error {
 	RWTestGoToCapn(seg, s)
-    _, err := seg.WriteTo(w)
-    return err
+	_, err := seg.WriteTo(w)
+	return err
 }
 
 func (s *RWTest) Load(r io.Reader) error {
 	capMsg, err := capn.ReadFromStream(r, nil)
 	if err != nil {
-		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-        return err
+		return err
 	}
 	z := ReadRootRWTestCapn(capMsg)
-    RWTestCapnToGo(z, s)
-    return nil
+	RWTestCapnToGo(z, s)
+	return nil
 }
   
   func RWTestCapnToGo(src RWTestCapn, dest *RWTest) *RWTest { 
@@ -103,21 +107,25 @@ struct RWTestCapn {
 }
 
 func (s *RWTest) Save(w io.Writer) error {
-	seg := capn.NewBuffer(nil)
+	return s.SaveWith(capn.NewBuffer(nil), w)
+}
+
+// SaveWith is Save, but serializes into seg instead of a fresh buffer.
+// A CapnEncoder hands out reusable segments for this.
+func (s *RWTest) SaveWith(seg *capn.Segment, w io.Writer) error {
 	RWTestGoToCapn(seg, s)
-    _, err := seg.WriteTo(w)
-    return err
+	_, err := seg.WriteTo(w)
+	return err
 }
 
 func (s *RWTest) Load(r io.Reader) error {
 	capMsg, err := capn.ReadFromStream(r, nil)
 	if err != nil {
-		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-        return err
+		return err
 	}
 	z := ReadRootRWTestCapn(capMsg)
-    RWTestCapnToGo(z, s)
-    return nil
+	RWTestCapnToGo(z, s)
+	return nil
 }
 
   
@@ -89,10 +89,15 @@ struct S1Capn { myBigs  @0:   List(BigCapn); }
 
 
     func (s *Big) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *Big) SaveWith(seg *capn.Segment, w io.Writer) error {
     	BigGoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
    
   
@@ -100,12 +105,11 @@ struct S1Capn { myBigs  @0:   List(BigCapn); }
     func (s *Big) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootBigCapn(capMsg)
-        BigCapnToGo(z, s)
-        return nil
+    	BigCapnToGo(z, s)
+    	return nil
     }
 
 func BigCapnToGo(src BigCapn, dest *Big) *Big { 
@@ -121,10 +125,15 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
 
   
     func (s *s1) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
+    	return s.SaveWith(capn.NewBuffer(nil), w)
+    }
+
+    // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+    // A CapnEncoder hands out reusable segments for this.
+    func (s *s1) SaveWith(seg *capn.Segment, w io.Writer) error {
     	s1GoToCapn(seg, s)
     	_, err := seg.WriteTo(w)
-        return err
+    	return err
     }
    
   
@@ -132,12 +141,11 @@ func BigGoToCapn(seg *capn.Segment, src *Big) BigCapn {
     func (s *s1) Load(r io.Reader) error {
     	capMsg, err := capn.ReadFromStream(r, nil)
     	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
+    		return err
     	}
     	z := ReadRootS1Capn(capMsg)
-        S1CapnToGo(z, s)
-        return nil
+    	S1CapnToGo(z, s)
+    	return nil
     }
 
 func S1CapnToGo(src S1Capn, dest *s1) *s1 {
@@ -189,23 +197,27 @@ struct MiniCapn {
   
   
   func (s *Cooper) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	CooperGoToCapn(seg, s)
-    	_, err := seg.WriteTo(w)
-        return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *Cooper) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	CooperGoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
    
   
    
   func (s *Cooper) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
-    	}
-    	z := ReadRootCooperCapn(capMsg)
-        CooperCapnToGo(z, s)
-        return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootCooperCapn(capMsg)
+  	CooperCapnToGo(z, s)
+  	return nil
   }
   
   
@@ -269,23 +281,27 @@ struct MiniCapn {
   
   
   func (s *Mini) Save(w io.Writer) error {
-    	seg := capn.NewBuffer(nil)
-    	MiniGoToCapn(seg, s)
-    	_, err := seg.WriteTo(w)
-        return err
+  	return s.SaveWith(capn.NewBuffer(nil), w)
+  }
+
+  // SaveWith is Save, but serializes into seg instead of a fresh buffer.
+  // A CapnEncoder hands out reusable segments for this.
+  func (s *Mini) SaveWith(seg *capn.Segment, w io.Writer) error {
+  	MiniGoToCapn(seg, s)
+  	_, err := seg.WriteTo(w)
+  	return err
   }
    
   
    
   func (s *Mini) Load(r io.Reader) error {
-    	capMsg, err := capn.ReadFromStream(r, nil)
-    	if err != nil {
-    		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-            return err
-    	}
-    	z := ReadRootMiniCapn(capMsg)
-        MiniCapnToGo(z, s)
-        return nil
+  	capMsg, err := capn.ReadFromStream(r, nil)
+  	if err != nil {
+  		return err
+  	}
+  	z := ReadRootMiniCapn(capMsg)
+  	MiniCapnToGo(z, s)
+  	return nil
   }
   
   
@@ -353,21 +369,25 @@ struct CooperCapn {
 } 
 
 func (s *Cooper) Save(w io.Writer) error {
-	seg := capn.NewBuffer(nil)
+	return s.SaveWith(capn.NewBuffer(nil), w)
+}
+
+// SaveWith is Save, but serializes into seg instead of a fresh buffer.
+// A CapnEncoder hands out reusable segments for this.
+func (s *Cooper) SaveWith(seg *capn.Segment, w io.Writer) error {
 	CooperGoToCapn(seg, s)
 	_, err := seg.WriteTo(w)
-    return err
+	return err
 }
 
 func (s *Cooper) Load(r io.Reader) error {
 	capMsg, err := capn.ReadFromStream(r, nil)
 	if err != nil {
-		//panic(fmt.Errorf("capn.ReadFromStream error: %s", err))
-        return err
+		return err
 	}
 	z := ReadRootCooperCapn(capMsg)
 	CooperCapnToGo(z, s)
-    return nil
+	return nil
 }
 
 func CooperCapnToGo(src CooperCapn, dest *Cooper) *Cooper {
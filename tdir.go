@@ -3,7 +3,6 @@ package main
 import (
 	"io/ioutil"
 	"os"
-	"os/exec"
 )
 
 type TempDir struct {
@@ -23,7 +22,10 @@ func NewTempDir() *TempDir {
 	}
 
 	// add files needed for capnpc -ogo compilation
-	exec.Command("/bin/cp", "go.capnp", dirname).Run()
+	err = WriteGoCapnp(dirname)
+	if err != nil {
+		panic(err)
+	}
 
 	return &TempDir{
 		OrigDir: origdir,
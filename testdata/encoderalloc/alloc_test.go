@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// run by Test023EncoderFixtureAllocatesLessThanSave in bambam's
+// encoder_test.go: a CapnEncoder reuses its segment from one message
+// to the next, so it should allocate less per message than Save,
+// which starts a fresh buffer each time.
+
+func allocPayload() *Payload {
+	p := &Payload{Name: "payload"}
+	for i := 0; i < 256; i++ {
+		p.Nums = append(p.Nums, int64(i))
+		p.Words = append(p.Words, "word")
+	}
+	return p
+}
+
+func TestEncoderAllocatesLessThanSave(t *testing.T) {
+	p := allocPayload()
+	save := testing.AllocsPerRun(100, func() {
+		if err := p.Save(ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	enc := NewCapnEncoder(ioutil.Discard)
+	encode := testing.AllocsPerRun(100, func() {
+		if err := enc.Encode(p); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Logf("allocations per message: Save %v, CapnEncoder.Encode %v", save, encode)
+	if encode >= save {
+		t.Fatalf("CapnEncoder.Encode made %v allocations per message, not fewer than Save's %v", encode, save)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+// used in encoder_test.go: encoder_bench_test.go.txt benchmarks Save
+// against CapnEncoder.Encode for this struct.
+
+type Payload struct {
+	Name  string
+	Nums  []int64
+	Words []string
+}
+
+func main() {}
@@ -0,0 +1,8 @@
+module encoderalloc
+
+go 1.16
+
+require (
+	github.com/glycerine/go-capnproto v0.0.0-20190118050403-2d07de3aa7fc
+	github.com/glycerine/rbtree v0.0.0-20180524195614-80eebfe947f7
+)
@@ -0,0 +1,22 @@
+package main
+
+// The capnpc-go bindings for schema.capnp, as capnpc -ogo writes them,
+// trimmed to what translateCapn.go uses. They are checked in so that
+// alloc_test.go runs without the capnp tools.
+
+import (
+	C "github.com/glycerine/go-capnproto"
+)
+
+type PayloadCapn C.Struct
+
+func NewPayloadCapn(s *C.Segment) PayloadCapn      { return PayloadCapn(s.NewStruct(0, 3)) }
+func NewRootPayloadCapn(s *C.Segment) PayloadCapn  { return PayloadCapn(s.NewRootStruct(0, 3)) }
+func AutoNewPayloadCapn(s *C.Segment) PayloadCapn  { return PayloadCapn(s.NewStructAR(0, 3)) }
+func ReadRootPayloadCapn(s *C.Segment) PayloadCapn { return PayloadCapn(s.Root(0).ToStruct()) }
+func (s PayloadCapn) Name() string                 { return C.Struct(s).GetObject(0).ToText() }
+func (s PayloadCapn) SetName(v string)             { C.Struct(s).SetObject(0, s.Segment.NewText(v)) }
+func (s PayloadCapn) Nums() C.Int64List            { return C.Int64List(C.Struct(s).GetObject(1)) }
+func (s PayloadCapn) SetNums(v C.Int64List)        { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s PayloadCapn) Words() C.TextList            { return C.TextList(C.Struct(s).GetObject(2)) }
+func (s PayloadCapn) SetWords(v C.TextList)        { C.Struct(s).SetObject(2, C.Object(v)) }
@@ -0,0 +1,208 @@
+// Code generated by bambam. DO NOT EDIT.
+
+package main
+
+import (
+	"io"
+
+	capn "github.com/glycerine/go-capnproto"
+)
+
+func (s *Payload) Save(w io.Writer) error {
+	return s.SaveWith(capn.NewBuffer(nil), w)
+}
+
+// SaveWith is Save, but serializes into seg instead of a fresh buffer.
+// A CapnEncoder hands out reusable segments for this.
+func (s *Payload) SaveWith(seg *capn.Segment, w io.Writer) error {
+	PayloadGoToCapn(seg, s)
+	_, err := seg.WriteTo(w)
+	return err
+}
+
+func (s *Payload) Load(r io.Reader) error {
+	capMsg, err := capn.ReadFromStream(r, nil)
+	if err != nil {
+		return err
+	}
+	z := ReadRootPayloadCapn(capMsg)
+	PayloadCapnToGo(z, s)
+	return nil
+}
+
+func PayloadCapnToGo(src PayloadCapn, dest *Payload) *Payload {
+	if dest == nil {
+		dest = &Payload{}
+	}
+	dest.Name = src.Name()
+
+	var n int
+
+	// Nums
+	n = src.Nums().Len()
+	dest.Nums = make([]int64, n)
+	for i := 0; i < n; i++ {
+		dest.Nums[i] = int64(src.Nums().At(i))
+	}
+
+	dest.Words = src.Words().ToArray()
+
+	return dest
+}
+
+func PayloadGoToCapn(seg *capn.Segment, src *Payload) PayloadCapn {
+	dest := AutoNewPayloadCapn(seg)
+	dest.SetName(src.Name)
+
+	mylist1 := seg.NewInt64List(len(src.Nums))
+	for i := range src.Nums {
+		mylist1.Set(i, int64(src.Nums[i]))
+	}
+	dest.SetNums(mylist1)
+
+	mylist2 := seg.NewTextList(len(src.Words))
+	for i := range src.Words {
+		mylist2.Set(i, string(src.Words[i]))
+	}
+	dest.SetWords(mylist2)
+
+	return dest
+}
+
+func SliceInt64ToInt64List(seg *capn.Segment, m []int64) capn.Int64List {
+	lst := seg.NewInt64List(len(m))
+	for i := range m {
+		lst.Set(i, int64(m[i]))
+	}
+	return lst
+}
+
+func Int64ListToSliceInt64(p capn.Int64List) []int64 {
+	v := make([]int64, p.Len())
+	for i := range v {
+		v[i] = int64(p.At(i))
+	}
+	return v
+}
+
+func SliceStringToTextList(seg *capn.Segment, m []string) capn.TextList {
+	lst := seg.NewTextList(len(m))
+	for i := range m {
+		lst.Set(i, string(m[i]))
+	}
+	return lst
+}
+
+func TextListToSliceString(p capn.TextList) []string {
+	v := make([]string, p.Len())
+	for i := range v {
+		v[i] = string(p.At(i))
+	}
+	return v
+}
+
+// CapnPayloadView gives read-only access to a PayloadCapn, converting each
+// field to its Go type lazily, as the field is asked for.
+type CapnPayloadView struct {
+	src PayloadCapn
+}
+
+func NewCapnPayloadView(src PayloadCapn) CapnPayloadView {
+	return CapnPayloadView{src: src}
+}
+
+// ReadCapnPayloadView reads one message from r and returns a view of its root.
+func ReadCapnPayloadView(r io.Reader) (CapnPayloadView, error) {
+	capMsg, err := capn.ReadFromStream(r, nil)
+	if err != nil {
+		return CapnPayloadView{}, err
+	}
+	return NewCapnPayloadView(ReadRootPayloadCapn(capMsg)), nil
+}
+
+// Capn returns the underlying capnp reader.
+func (v CapnPayloadView) Capn() PayloadCapn {
+	return v.src
+}
+
+// ToGo materializes the whole Payload.
+func (v CapnPayloadView) ToGo() *Payload {
+	return PayloadCapnToGo(v.src, nil)
+}
+
+func (v CapnPayloadView) Name() string {
+	return string(v.src.Name())
+}
+
+func (v CapnPayloadView) Nums() CapnSliceInt64View {
+	return CapnSliceInt64View{src: capn.Int64List(v.src.Nums())}
+}
+
+func (v CapnPayloadView) Words() []string {
+	return v.src.Words().ToArray()
+}
+
+// CapnSliceInt64View is a read-only view of a capnp list that converts
+// elements to Go as they are accessed.
+type CapnSliceInt64View struct {
+	src capn.Int64List
+}
+
+func (v CapnSliceInt64View) Len() int {
+	return v.src.Len()
+}
+
+func (v CapnSliceInt64View) At(i int) int64 {
+	return int64(v.src.At(i))
+}
+
+// ToSlice materializes the whole list.
+func (v CapnSliceInt64View) ToSlice() []int64 {
+	s := make([]int64, v.Len())
+	for i := range s {
+		s[i] = v.At(i)
+	}
+	return s
+}
+
+// CapnSaver is implemented by every struct that bambam generated a
+// Save() method for.
+type CapnSaver interface {
+	SaveWith(seg *capn.Segment, w io.Writer) error
+}
+
+// CapnEncoder writes messages to w, reusing one arena across messages
+// instead of allocating fresh segments for each Save.
+// A CapnEncoder is not safe for concurrent use.
+type CapnEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewCapnEncoder(w io.Writer) *CapnEncoder {
+	return &CapnEncoder{w: w}
+}
+
+// Segment returns an empty segment backed by the encoder's arena.
+// Anything built in a previous segment is invalid after this call.
+func (e *CapnEncoder) Segment() *capn.Segment {
+	arena := e.buf[:cap(e.buf)]
+	for i := range arena {
+		arena[i] = 0
+	}
+	return capn.NewBuffer(e.buf[:0])
+}
+
+// Encode serializes m to the encoder's writer.
+func (e *CapnEncoder) Encode(m CapnSaver) error {
+	seg := e.Segment()
+	err := m.SaveWith(seg, e.w)
+	// keep the (possibly grown) arena for the next message.
+	e.buf = seg.Data
+	return err
+}
+
+// Reset points the encoder at a new writer, keeping its arena.
+func (e *CapnEncoder) Reset(w io.Writer) {
+	e.w = w
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// The Go that bambam generates comes from the text/template files in
+// templates/, compiled into the binary. bambam -templates dir parses
+// any dir/*.tmpl after the defaults, so a file there only needs to
+// {{define}} the blocks it wants to replace; see templates/README.md.
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// templateFuncs are available to every template, defaults and overrides alike.
+var templateFuncs = template.FuncMap{
+	"upperFirst": UppercaseFirstLetter,
+	"goToCapn":   goToCapnFunc,
+}
+
+// goToCapnFunc names the function that translates a Go goType to capnp:
+// TGoToCapn for a struct T, and Complex128GoToCapn for a complex128, to
+// pair with Complex128CapnToGo.
+func goToCapnFunc(goType string) string {
+	if _, isComplex := complexPartTypes[goType]; isComplex {
+		return UppercaseFirstLetter(goType) + "GoToCapn"
+	}
+	return goType + "GoToCapn"
+}
+
+// LoadTemplates parses the default templates, then any overrides in dir.
+func LoadTemplates(dir string) (*template.Template, error) {
+	t, err := template.New("bambam").Funcs(templateFuncs).ParseFS(defaultTemplates, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) == 0 {
+		return nil, fmt.Errorf("no .tmpl files found in templates directory '%s'", dir)
+	}
+	return t.ParseFiles(overrides...)
+}
+
+func mustLoadDefaultTemplates() *template.Template {
+	t, err := LoadTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// render executes template name with data as dot.
+func (x *Extractor) render(name string, data interface{}) []byte {
+	var buf bytes.Buffer
+	err := x.tmpl.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		panic(fmt.Errorf("bambam: executing template '%s': %s", name, err))
+	}
+	return buf.Bytes()
+}
+
+// FileData is dot for the templates that start a generated file.
+type FileData struct {
+	PkgName string
+
+	// for header: the translators return errors made with fmt.Errorf.
+	ImportFmt bool
+
+	// for testsHeader: how deeply the random populators nest.
+	RandomMaxDepth int
+}
+
+// translatorsUseFmt reports whether the translators call fmt.Errorf,
+// as they do for a struct whose Save or Load can fail.
+func (x *Extractor) translatorsUseFmt() bool {
+	for _, s := range x.srs {
+		if x.reachesUnion(s, make(map[string]bool)) || x.loadMayFail(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Kinds of Field, as far as the translators are concerned.
+const (
+	KindScalar         = "Scalar"         // bool, int, float64, string, ...
+	KindStruct         = "Struct"         // T, where T is a struct
+	KindStructPtr      = "StructPtr"      // *T
+	KindPrimList       = "PrimList"       // []int, []string, ...
+	KindPrimListList   = "PrimListList"   // [][]int
+	KindStructList     = "StructList"     // []T or []*T
+	KindStructListList = "StructListList" // [][]T
+)
+
+// Struct, as seen from the templates.
+
+func (s *Struct) GoName() string   { return s.goName }
+func (s *Struct) CapName() string  { return s.capName }
+func (s *Struct) Fields() []*Field { return s.fld }
+
+// Field, as seen from the templates.
+
+func (f *Field) GoName() string    { return f.goName }      // Go field name
+func (f *Field) CapName() string   { return f.capname }     // capnp schema field name
+func (f *Field) CapGoName() string { return f.goCapGoName } // capnpc-go accessor: X(), SetX()
+func (f *Field) Embedded() bool    { return f.embedded }
+
+// GoType is the innermost Go type, e.g. Big for []*Big.
+func (f *Field) GoType() string { return f.goType }
+
+// GoTypePrefix is what wraps GoType, e.g. []* for []*Big.
+func (f *Field) GoTypePrefix() string { return f.goTypePrefix }
+
+// GoTypeString is the full Go type, e.g. []*Big.
+func (f *Field) GoTypeString() string { return f.goTypePrefix + f.goType }
+
+// CapType is the type as written in the schema, e.g. List(BigCapn).
+func (f *Field) CapType() string { return f.capType }
+
+// CapBaseType is the innermost capnp type, e.g. Int64 or BigCapn.
+func (f *Field) CapBaseType() string { return last(f.capTypeSeq) }
+
+// CapGoBaseType is the Go type the capnp accessors use for CapBaseType, e.g. int64.
+func (f *Field) CapGoBaseType() string { return last(f.goCapGoTypeSeq) }
+
+// ScalarCast reports whether a scalar's Go type must be converted to
+// and from CapGoBaseType, as int is to int64, and Kind to uint16 for
+// type Kind uint16.
+func (f *Field) ScalarCast() bool {
+	if f.namedType != "" {
+		return true
+	}
+	switch f.goType {
+	case "int", "uint", "uintptr":
+		return true
+	}
+	return false
+}
+
+// IsPointer reports whether the (element) Go type is a pointer: *T or []*T.
+func (f *Field) IsPointer() bool { return isPointerType(f.goTypePrefix) }
+
+// Kind is one of the Kind constants.
+func (f *Field) Kind() string { return f.kind }
+
+// ListNum numbers the list fields of a struct, 1, 2, ..., for local variable names.
+func (f *Field) ListNum() int { return f.listNum }
+
+// FirstListToGo is true for the first list field that XCapnToGo
+// copies element by element, where it declares its n.
+func (f *Field) FirstListToGo() bool { return f.firstListToGo }
+
+// SliceToListFunc and ListToSliceFunc name the helpers that convert
+// the inner slices of a [][]T field; SingleCapListType is the capn
+// type of those inner lists.
+func (f *Field) SliceToListFunc() string   { return f.canonGoTypeSliceToListFunc }
+func (f *Field) ListToSliceFunc() string   { return f.canonGoTypeListToSliceFunc }
+func (f *Field) SingleCapListType() string { return f.singleCapListType }
+
+// prepareStruct sets the Kind, ListNum, FirstListToGo, LoadDepth,
+// ToGoMayFail and ToCapnMayFail of each field of s, and s's
+// LoadDepthLimit, LoadMayFail and unions, ahead of rendering its
+// translators. MarkRecursive must have run.
+func (x *Extractor) prepareStruct(s *Struct) {
+	s.loadDepthLimit = 0
+	if s.recursive {
+		s.loadDepthLimit = x.maxLoadDepth
+	}
+	s.loadMayFail = x.loadMayFail(s)
+	s.unions = s.buildUnions()
+	s.saveMayFail = x.reachesUnion(s, make(map[string]bool))
+
+	listNum := 0
+	seenNonTextList := false
+	for _, f := range s.fld {
+		f.kind = x.fieldKind(f)
+		f.listNum = 0
+		f.firstListToGo = false
+		f.loadDepth = false
+		f.toGoMayFail = false
+		f.toCapnMayFail = false
+		if t := x.srs[last(fieldGoTypeSeq(f))]; t != nil {
+			f.loadDepth = s.loadDepthLimit > 0 && t.recursive
+			f.toGoMayFail = x.loadMayFail(t)
+			f.toCapnMayFail = x.reachesUnion(t, make(map[string]bool))
+		}
+
+		switch f.kind {
+		case KindScalar, KindStruct, KindStructPtr:
+			continue
+		}
+		listNum++
+		f.listNum = listNum
+		if f.capType != "List(Text)" && !seenNonTextList {
+			f.firstListToGo = true
+			seenNonTextList = true
+		}
+	}
+}
+
+func (x *Extractor) fieldKind(f *Field) string {
+	goTypeSeq := fieldGoTypeSeq(f)
+
+	if f.isList {
+		listList := strings.HasPrefix(f.goTypePrefix, "[][]")
+		switch {
+		case IsIntrinsicGoType(f.goType) && listList:
+			return KindPrimListList
+		case IsIntrinsicGoType(f.goType):
+			return KindPrimList
+		case listList:
+			return KindStructListList
+		default:
+			return KindStructList
+		}
+	}
+
+	if goTypeSeq[0] == "*" && len(goTypeSeq) > 1 && !IsIntrinsicGoType(goTypeSeq[1]) {
+		return KindStructPtr
+	}
+	if _, isCapType := x.goType2capTypeCache[goTypeSeq[0]]; isCapType {
+		return KindStruct
+	}
+	return KindScalar
+}
+
+// ListHelper is dot for the sliceToList and listToSlice templates,
+// which convert one level of slice <-> capnp list.
+type ListHelper struct {
+	SliceToListFunc string // e.g. SliceIntToInt64List
+	ListToSliceFunc string // e.g. Int64ListToSliceInt
+	GoType          string // e.g. []int
+	GoBaseType      string // e.g. int
+	CapListType     string // e.g. capn.Int64List
+	CapBaseType     string // e.g. Int64
+	CapGoBaseType   string // e.g. int64
+	NewListExpr     string // allocates a list of len(m) in seg
+	BaseIsIntrinsic bool
+	ElemIsPointer   bool // GoType is a []*GoBaseType; a nil element is saved as a zero struct
+	ToCapnMayFail   bool // GoBaseType's GoToCapn returns an error too
+	ToGoMayFail     bool // and its CapnToGo
+}
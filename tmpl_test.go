@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestTemplateOverrideChangesOutput(t *testing.T) {
+
+	cv.Convey("Given a -templates dir that redefines only the \"save\" template", t, func() {
+		cv.Convey("then Save should come from the override, while Load still comes from the defaults", func() {
+
+			dir, err := ioutil.TempDir("", "bambam-tmpl")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			err = ioutil.WriteFile(filepath.Join(dir, "mysave.tmpl"), []byte(`{{define "save"}}
+func (s *{{.GoName}}) Save(w io.Writer) error {
+	return fmt.Errorf("{{.GoName}} ({{len .Fields}} fields) is not saved here")
+}
+{{end}}`), 0644)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			tmpl, err := LoadTemplates(dir)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			x := NewExtractor()
+			defer x.Cleanup()
+			x.tmpl = tmpl
+			_, err = ExtractStructs("", "package main; type Rect struct { W int; H int }", x)
+			cv.So(err, cv.ShouldEqual, nil)
+
+			var buf bytes.Buffer
+			_, err = x.WriteToTranslators(&buf)
+			cv.So(err, cv.ShouldEqual, nil)
+			out := buf.String()
+
+			cv.So(out, ShouldContainModuloWhiteSpace, `
+func (s *Rect) Save(w io.Writer) error {
+	return fmt.Errorf("Rect (2 fields) is not saved here")
+}`)
+			cv.So(out, ShouldContainModuloWhiteSpace, `
+func (s *Rect) Load(r io.Reader) error {`)
+		})
+	})
+
+	cv.Convey("Given a -templates dir with no .tmpl files in it", t, func() {
+		cv.Convey("then LoadTemplates should return an error", func() {
+			dir, err := ioutil.TempDir("", "bambam-tmpl")
+			cv.So(err, cv.ShouldEqual, nil)
+			defer os.RemoveAll(dir)
+
+			_, err = LoadTemplates(dir)
+			cv.So(err != nil, cv.ShouldEqual, true)
+		})
+	})
+}
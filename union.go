@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Union is a named capnp union, made of the *T fields of a struct
+// tagged with the same capunion:"name". At most one of them may be
+// set; Save fails otherwise, and Load sets only the one Which() names.
+type Union struct {
+	Name         string   // schema name of the union, e.g. outcome
+	GoName       string   // capnpc-go accessor of its group, e.g. Outcome
+	StructGoName string   // the Go struct it is in
+	Members      []*Field // in the order of the struct's fields
+	whichPrefix  string   // of capnpc-go's Which() constants, e.g. MSGCAPNOUTCOME_
+}
+
+// MemberNames lists the Go names of the union's fields, e.g. Ok, Err.
+func (u *Union) MemberNames() string {
+	names := make([]string, len(u.Members))
+	for i, f := range u.Members {
+		names[i] = f.goName
+	}
+	return strings.Join(names, ", ")
+}
+
+var regexUnionName = regexp.MustCompile(`^[a-z][A-Za-z0-9]*$`)
+
+// capunionFromTag returns the value of the capunion key in the struct
+// tag literal tagLit, e.g. outcome for `capunion:"outcome"`.
+func capunionFromTag(tagLit string) string {
+	unquoted, err := strconv.Unquote(tagLit)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(unquoted).Get("capunion")
+}
+
+// checkUnions checks the capunion tags of s, once all its fields are
+// known: a union needs two fields or more, and a name that no field
+// of s has.
+func (x *Extractor) checkUnions(s *Struct) error {
+	count := make(map[string]int)
+	for _, f := range s.fld {
+		if f.union != "" {
+			count[f.union]++
+		}
+	}
+	for _, f := range s.fld {
+		if f.union == "" {
+			continue
+		}
+		if count[f.union] < 2 {
+			return fmt.Errorf(`problem in capunion tag '%s' on field '%s' in struct '%s': a union needs two fields or more`, f.union, f.goName, s.goName)
+		}
+		for _, g := range s.fld {
+			if g.capname == f.union {
+				return fmt.Errorf(`problem in capunion tag '%s' on field '%s' in struct '%s': field '%s' already has the name '%s'`, f.union, f.goName, s.goName, g.goName, f.union)
+			}
+		}
+	}
+	return nil
+}
+
+// buildUnions groups the fields of s by their capunion tag, in the
+// current order of s.fld, and points each member at its Union.
+func (s *Struct) buildUnions() []*Union {
+	var unions []*Union
+	byName := make(map[string]*Union)
+	for _, f := range s.fld {
+		f.unionGroup = nil
+		if f.union == "" {
+			continue
+		}
+		u := byName[f.union]
+		if u == nil {
+			u = &Union{
+				Name:         f.union,
+				GoName:       UppercaseFirstLetter(f.union),
+				StructGoName: s.goName,
+				whichPrefix:  strings.ToUpper(s.capName+UppercaseFirstLetter(f.union)) + "_",
+			}
+			byName[f.union] = u
+			unions = append(unions, u)
+		}
+		u.Members = append(u.Members, f)
+		f.unionGroup = u
+	}
+	return unions
+}
+
+// reachesUnion reports whether s, or a struct reachable from its
+// fields, has a union, so that XGoToCapn may return an error for it.
+func (x *Extractor) reachesUnion(s *Struct, seen map[string]bool) bool {
+	if seen[s.goName] {
+		return false
+	}
+	seen[s.goName] = true
+	for _, f := range s.fld {
+		if f.union != "" {
+			return true
+		}
+		if t := x.srs[last(fieldGoTypeSeq(f))]; t != nil && x.reachesUnion(t, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveMayFail reports whether saving s can fail on a union with more
+// than one field set, in s or in a struct it contains.
+func (s *Struct) SaveMayFail() bool { return s.saveMayFail }
+
+// ToCapnMayFail reports whether the GoToCapn of the struct f refers to
+// returns an error, as a struct's does when SaveMayFail.
+func (f *Field) ToCapnMayFail() bool { return f.toCapnMayFail }
+
+// InUnion reports whether f is a member of a union.
+func (f *Field) InUnion() bool { return f.union != "" }
+
+// UnionHead is f's Union if f is its first member, or else nil. The
+// templates write a union out where its first member would go.
+func (f *Field) UnionHead() *Union {
+	if f.unionGroup != nil && f.unionGroup.Members[0] == f {
+		return f.unionGroup
+	}
+	return nil
+}
+
+// WhichConst is the capnpc-go constant Which() returns when f is the
+// union member that is set, e.g. MSGCAPNOUTCOME_OK.
+func (f *Field) WhichConst() string {
+	return f.unionGroup.whichPrefix + strings.ToUpper(f.capname)
+}
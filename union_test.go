@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+const unionSrc = `
+type Result struct { Value int }
+type Failure struct { Reason string }
+type Msg struct {
+	ID  int
+	Ok  *Result  ` + "`capunion:\"outcome\"`" + `
+	Err *Failure ` + "`capunion:\"outcome\"`" + `
+}
+type Env struct { M Msg }
+`
+
+func TestCapunionTag(t *testing.T) {
+
+	cv.Convey("Given *T fields tagged capunion:\"outcome\"", t, func() {
+		cv.Convey("then the schema should group them in a named union, keeping their ordinals", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+unionSrc, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			var schema bytes.Buffer
+			_, err = x.WriteToSchema(&schema)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(schema.String(), ShouldContainModuloWhiteSpace, `struct MsgCapn { iD @0: Int64; outcome :union { ok @1: ResultCapn; err @2: FailureCapn; } }`)
+			cv.So(len(ValidateCapnpFile("union", schema.Bytes())), cv.ShouldEqual, 0)
+		})
+
+		cv.Convey("then MsgGoToCapn should refuse more than one set field, and set the other through the union's group", func() {
+			toCapn := ExtractGoToCapnCode(unionSrc, "Msg")
+			cv.So(toCapn, ShouldContainModuloWhiteSpace, `
+	if unionOutcome > 1 {
+		return dest, fmt.Errorf("MsgGoToCapn: more than one of the union outcome fields Ok, Err is set")
+	}
+	if src.Ok != nil {
+		dest.Outcome().SetOk(ResultGoToCapn(seg, src.Ok))
+	}
+	if src.Err != nil {
+		dest.Outcome().SetErr(FailureGoToCapn(seg, src.Err))
+	}`)
+		})
+
+		cv.Convey("then MsgCapnToGo should set only the field that Which() names", func() {
+			toGo := ExtractCapnToGoCode(unionSrc, "Msg")
+			cv.So(toGo, ShouldContainModuloWhiteSpace, `
+	dest.Ok = nil
+	dest.Err = nil
+	switch src.Outcome().Which() {
+	case MSGCAPNOUTCOME_OK:
+		if capn.Object(src.Outcome().Ok()).Type() != capn.TypeNull {
+			dest.Ok = ResultCapnToGo(src.Outcome().Ok(), nil)
+		}
+	case MSGCAPNOUTCOME_ERR:`)
+		})
+
+		cv.Convey("then Save should report a union with two fields set as an error, for Msg and for the Env holding one", func() {
+			x := NewExtractor()
+			defer x.Cleanup()
+			_, err := ExtractStructs("", "package main; "+unionSrc, x)
+			cv.So(err, cv.ShouldEqual, nil)
+			x.GenerateTranslators()
+			cv.So(string(x.SaveCode["Msg"]), ShouldContainModuloWhiteSpace, `
+	if _, err := MsgGoToCapn(seg, s); err != nil {
+		return fmt.Errorf("Msg.Save: %s", err)
+	}`)
+			cv.So(string(x.SaveCode["Env"]), ShouldContainModuloWhiteSpace, `EnvGoToCapn(seg, s); err != nil`)
+			cv.So(string(x.ToCapnCode["Msg"]), ShouldContainModuloWhiteSpace, `func MsgGoToCapn(seg *capn.Segment, src *Msg) (MsgCapn, error) {`)
+			cv.So(string(x.ToCapnCode["Env"]), ShouldContainModuloWhiteSpace, `
+func EnvGoToCapn(seg *capn.Segment, src *Env) (EnvCapn, error) {
+	dest := AutoNewEnvCapn(seg)
+	capnM, err := MsgGoToCapn(seg, &src.M)
+	if err != nil {
+		return dest, err
+	}
+	dest.SetM(capnM)
+
+	return dest, nil
+}`)
+			cv.So(string(x.ToCapnCode["Result"]), ShouldContainModuloWhiteSpace, `func ResultGoToCapn(seg *capn.Segment, src *Result) ResultCapn {`)
+			cv.So(string(x.SaveCode["Result"]), ShouldContainModuloWhiteSpace, `
+	ResultGoToCapn(seg, s)
+	_, err := seg.WriteTo(w)`)
+		})
+
+		cv.Convey("then lists of a struct holding a union should pass the error up too", func() {
+			src := unionSrc + "type Envs struct { Ps []*Msg; Ls [][]Msg }\n"
+			toCapn := ExtractGoToCapnCode(src, "Envs")
+			cv.So(toCapn, ShouldContainModuloWhiteSpace, `
+			if ele != nil {
+				v, err := MsgGoToCapn(seg, ele)
+				if err != nil {
+					return dest, err
+				}
+				plist.Set(i, capn.Object(v))
+			}`)
+			cv.So(toCapn, ShouldContainModuloWhiteSpace, `
+			v, err := SliceMsgToMsgCapnList(seg, ele)
+			if err != nil {
+				return dest, err
+			}`)
+			cv.So(ExtractString2String(src), ShouldContainModuloWhiteSpace, `
+func SliceMsgToMsgCapnList(seg *capn.Segment, m []Msg) (MsgCapn_List, error) {
+	lst := NewMsgCapnList(seg, len(m))
+	for i := range m {
+		v, err := MsgGoToCapn(seg, &m[i])
+		if err != nil {
+			return lst, err
+		}
+		lst.Set(i, v)
+	}
+	return lst, nil
+}`)
+		})
+
+		cv.Convey("then the view getter should return false unless its field is the one set, and not null", func() {
+			cv.So(ExtractViewString(unionSrc), ShouldContainModuloWhiteSpace, `
+// Ok returns false if Ok is nil, or another field of its union is set.
+func (v CapnMsgView) Ok() (CapnResultView, bool) {
+	if v.src.Outcome().Which() != MSGCAPNOUTCOME_OK {
+		return CapnResultView{}, false
+	}
+	if capn.Object(v.src.Outcome().Ok()).Type() == capn.TypeNull {
+		return CapnResultView{}, false
+	}
+	return NewCapnResultView(v.src.Outcome().Ok()), true
+}`)
+		})
+
+		cv.Convey("then the random populator for -gentests should set at most one of them", func() {
+			cv.So(ExtractTestsString(unionSrc), ShouldContainModuloWhiteSpace, `
+	switch r.Intn(3) {
+	case 0:
+		s.Ok = bambamRandPtrResult(r, depth+1)
+	case 1:
+		s.Err = bambamRandPtrFailure(r, depth+1)
+	}`)
+		})
+	})
+
+	cv.Convey("Given a misused capunion tag", t, func() {
+		cv.Convey("then a union of one field should be refused", func() {
+			_, err := ExtractFromString("type R struct { A int }\ntype M struct { Ok *R `capunion:\"outcome\"`; B int }")
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "a union needs two fields or more"), cv.ShouldEqual, true)
+		})
+
+		cv.Convey("then a field that is not a pointer to a struct should be refused", func() {
+			_, err := ExtractFromString("type R struct { A int }\ntype M struct { Ok R `capunion:\"outcome\"`; N *int `capunion:\"outcome\"` }")
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "only pointer-to-struct fields"), cv.ShouldEqual, true)
+		})
+
+		cv.Convey("then a union named like a field should be refused", func() {
+			_, err := ExtractFromString("type R struct { A int }\ntype M struct { Outcome int; Ok *R `capunion:\"outcome\"`; Err *R `capunion:\"outcome\"` }")
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "field 'Outcome' already has the name 'outcome'"), cv.ShouldEqual, true)
+		})
+
+		cv.Convey("then flattening a struct with a union should be refused", func() {
+			_, _, err := flattenExtract("type R struct { A int }\ntype U struct { Ok *R `capunion:\"outcome\"`; Err *R `capunion:\"outcome\"` }\ntype D struct { U `capid:\"flatten\"` }", false)
+			cv.So(err == nil, cv.ShouldEqual, false)
+			cv.So(strings.Contains(err.Error(), "a union can't be flattened"), cv.ShouldEqual, true)
+		})
+	})
+
+	cv.Convey("Given a .capnp schema with a named union of structs", t, func() {
+		cv.Convey("then -from-capnp should write capunion tagged pointers, without a warning", func() {
+			schema, err := ParseCapnpSchema("u.capnp", []byte("struct R { a @0 :Int64; }\nstruct F { b @0 :Text; }\nstruct M {\n  id @0 :Int64;\n  outcome :union {\n    ok @1 :R;\n    err @2 :F;\n  }\n}\n"))
+			cv.So(err, cv.ShouldEqual, nil)
+			src, warnings, err := GoFromCapnp(schema, "main", false)
+			cv.So(err, cv.ShouldEqual, nil)
+			cv.So(len(warnings), cv.ShouldEqual, 0)
+			cv.So(string(src), ShouldContainModuloWhiteSpace, "OutcomeOk *R `capid:\"1\" capunion:\"outcome\"`")
+			cv.So(string(src), ShouldContainModuloWhiteSpace, "OutcomeErr *F `capid:\"2\" capunion:\"outcome\"`")
+		})
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GenerateViews fills x.ViewCode and x.ListViewCode with read-only
+// CapnXView wrappers. A view holds the capnp reader and converts a field
+// to its Go type only when that field's getter is called, so hot paths
+// can pull one field out of a big message without a full XCapnToGo.
+// Like CapnEncoder, the views carry a Capn prefix so as not to collide
+// with the user's own names in their package.
+func (x *Extractor) GenerateViews() {
+
+	for _, s := range x.srs {
+
+		data := &ViewData{Struct: s}
+		s.unions = s.buildUnions()
+		s.loadMayFail = x.loadMayFail(s)
+		for _, f := range s.fld {
+			if f.unionGroup == nil {
+				expr := "v.src." + f.goCapGoName + "()"
+				typ, conv := x.viewFor(f.goTypeSeq, f.capTypeSeq, expr, f.keepNil)
+				if f.namedType != "" && !f.isList {
+					// type Kind uint16: hand back a Kind, not the uint16.
+					typ, conv = f.namedType, fmt.Sprintf("%s(v.src.%s())", f.namedType, f.goCapGoName)
+				}
+				g := ViewGetter{Name: f.goName, Type: typ, Conv: conv}
+				if isStructPtr(f.goTypeSeq) {
+					g.NullCheck = expr
+				}
+				data.Getters = append(data.Getters, g)
+				continue
+			}
+			// a union member is read through its group, and only if it is the one set.
+			group := "v.src." + f.unionGroup.GoName + "()"
+			expr := group + "." + f.goCapGoName + "()"
+			typ, conv := x.viewFor(f.goTypeSeq, f.capTypeSeq, expr, false)
+			data.Getters = append(data.Getters, ViewGetter{Name: f.goName, Type: typ, Conv: conv,
+				Guard: group + ".Which() != " + f.WhichConst(), NullCheck: expr})
+		}
+		x.ViewCode[s.goName] = x.render("view", data)
+	}
+}
+
+// ViewData is dot for the view template.
+type ViewData struct {
+	*Struct
+	Getters []ViewGetter
+}
+
+// ViewGetter is one field getter on a CapnXView: func (v CapnXView) Name() Type { return Conv }.
+// A *T field has a NullCheck, the capnp pointer it reads: its getter
+// returns (Type, bool), with false and the zero Type when that pointer
+// is null, or when Guard is true. A union member has a Guard, for when
+// another member of its union is set.
+type ViewGetter struct {
+	Name      string
+	Type      string
+	Conv      string
+	Guard     string
+	NullCheck string
+}
+
+// ListView is dot for the listView template.
+type ListView struct {
+	Name         string // e.g. CapnSliceIntView
+	CapnListType string // e.g. capn.Int64List
+	ElemType     string // what At(i) returns
+	ElemConv     string // how At(i) computes it from v.src.At(i)
+	ElemNull     string // for a capnil:"keep" []*T, the element pointer; At(i) then also returns false when it is null
+	GoType       string // what ToSlice() returns, e.g. []int
+	Fill         string // statement filling s[i] in ToSlice()
+	ToGoMayFail  bool   // ToSlice() returns an error too, from the elements' CapnToGo
+}
+
+// viewFor returns the type a view getter hands back for a value whose
+// go and capnp type sequences are goSeq and capSeq, along with the
+// expression that converts the capnp value expr into that type.
+// Lists of anything but Text get a list view, registered in x.ListViewCode.
+// keepNil is for a []*T tagged capnil:"keep", a list of PtrTCapn.
+func (x *Extractor) viewFor(goSeq []string, capSeq []string, expr string, keepNil bool) (typ string, conv string) {
+
+	if len(goSeq) > 1 && goSeq[0] == "*" {
+		return x.viewFor(goSeq[1:], capSeq[1:], expr, false)
+	}
+
+	if goSeq[0] == "[]" {
+		if len(goSeq) == 2 && goSeq[1] == "string" {
+			return "[]string", expr + ".ToArray()"
+		}
+		name := x.GenerateListView(goSeq, capSeq, keepNil)
+		return name, fmt.Sprintf("%s{src: %s(%s)}", name, capnListType(capSeq, keepNil), expr)
+	}
+
+	goType := goSeq[0]
+	if _, isComplex := complexPartTypes[goType]; isComplex {
+		return goType, fmt.Sprintf("*%sToGo(%s, nil)", last(capSeq), expr)
+	}
+	if IsIntrinsicGoType(goType) {
+		return goType, fmt.Sprintf("%s(%s)", goType, expr)
+	}
+	return "Capn" + goType + "View", fmt.Sprintf("NewCapn%sView(%s)", goType, expr)
+}
+
+// isStructPtr reports whether goSeq is a *T, with T a struct, which
+// capnp stores as a pointer that may be null.
+func isStructPtr(goSeq []string) bool {
+	return len(goSeq) == 2 && goSeq[0] == "*" && !IsIntrinsicGoType(goSeq[1])
+}
+
+// GenerateListView writes the list view for a go slice type (goSeq
+// starts with "[]") into x.ListViewCode, and returns its type name.
+// With keepNil, a []*T is read as the list of PtrTCapn that a capnil:"keep"
+// field is, in a view whose At(i) also reports a nil element.
+func (x *Extractor) GenerateListView(goSeq []string, capSeq []string, keepNil bool) string {
+
+	name := ViewTypeName(goSeq)
+	if keepNil {
+		name = strings.TrimSuffix(name, "View") + "OrNilView"
+	}
+	if _, already := x.ListViewCode[name]; already {
+		return name
+	}
+	// reserve the name before recursing on the element type.
+	x.ListViewCode[name] = nil
+
+	elemGoSeq := goSeq[1:]
+	elemCapSeq := capSeq[1:]
+	elemGoType := strings.Join(elemGoSeq, "")
+	// a capnil:"keep" []*T's elements are PtrTCapn structs, holding the T in ptr.
+	elemExpr := "v.src.At(i)"
+	if keepNil {
+		elemExpr = "v.src.At(i).Ptr()"
+	}
+	elemTyp, elemConv := x.viewFor(elemGoSeq, elemCapSeq, elemExpr, false)
+	t := x.srs[last(goSeq)]
+	mayFail := t != nil && x.loadMayFail(t)
+
+	// how to fill in element i of the materialized slice s.
+	var fill string
+	switch {
+	case elemGoSeq[0] == "[]" && elemTyp != elemGoType && mayFail:
+		fill = "elem, err := v.At(i).ToSlice()\n\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t\ts[i] = elem"
+	case elemGoSeq[0] == "[]" && elemTyp != elemGoType:
+		fill = "s[i] = v.At(i).ToSlice()"
+	case keepNil && mayFail:
+		fill = fmt.Sprintf("if capn.Object(%s).Type() != capn.TypeNull {\n\t\t\telem, err := %sToGo(%s, nil)\n\t\t\tif err != nil {\n\t\t\t\treturn nil, err\n\t\t\t}\n\t\t\ts[i] = elem\n\t\t}", elemExpr, last(elemCapSeq), elemExpr)
+	case keepNil:
+		// a null element stays nil.
+		fill = fmt.Sprintf("if capn.Object(%s).Type() != capn.TypeNull {\n\t\t\ts[i] = %sToGo(%s, nil)\n\t\t}", elemExpr, last(elemCapSeq), elemExpr)
+	case elemGoSeq[0] == "*" && mayFail:
+		fill = fmt.Sprintf("elem, err := %sToGo(v.src.At(i), nil)\n\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t\ts[i] = elem", last(elemCapSeq))
+	case elemGoSeq[0] == "*":
+		// a nil element was saved as a zero struct, and loads as one.
+		fill = fmt.Sprintf("s[i] = %sToGo(v.src.At(i), nil)", last(elemCapSeq))
+	case elemGoSeq[0] != "[]" && !IsIntrinsicGoType(elemGoType) && mayFail:
+		fill = fmt.Sprintf("if _, err := %sToGo(v.src.At(i), &s[i]); err != nil {\n\t\t\treturn nil, err\n\t\t}", last(elemCapSeq))
+	case elemGoSeq[0] != "[]" && !IsIntrinsicGoType(elemGoType):
+		fill = fmt.Sprintf("%sToGo(v.src.At(i), &s[i])", last(elemCapSeq))
+	default:
+		fill = "s[i] = v.At(i)"
+	}
+
+	lv := &ListView{
+		Name:         name,
+		CapnListType: capnListType(capSeq, keepNil),
+		ElemType:     elemTyp,
+		ElemConv:     elemConv,
+		GoType:       strings.Join(goSeq, ""),
+		Fill:         fill,
+		ToGoMayFail:  mayFail,
+	}
+	if keepNil {
+		lv.ElemNull = elemExpr
+	}
+	x.ListViewCode[name] = x.render("listView", lv)
+
+	return name
+}
+
+// ViewTypeName names the list view for goTypeSeq, e.g. []*Big -> CapnSlicePtrBigView.
+func ViewTypeName(goTypeSeq []string) string {
+	return "Capn" + TypeSeqName(goTypeSeq) + "View"
+}
+
+// TypeSeqName spells goTypeSeq as an identifier fragment, e.g. []*Big -> SlicePtrBig.
+func TypeSeqName(goTypeSeq []string) string {
+	var r string
+	for _, s := range goTypeSeq {
+		switch s {
+		case "[]":
+			r += "Slice"
+		case "*":
+			r += "Ptr"
+		default:
+			r += UppercaseFirstLetter(s)
+		}
+	}
+	return r
+}
+
+// capnListType gives the go-capnproto list type for capSeq, which starts
+// with "List". keepNil is for a []*T tagged capnil:"keep", a list of PtrTCapn.
+func capnListType(capSeq []string, keepNil bool) string {
+	if keepNil {
+		return ptrWrapperName(last(capSeq)) + "_List"
+	}
+	elem := capSeq[1]
+	switch elem {
+	case "List":
+		return "capn.PointerList"
+	case "*":
+		return last(capSeq) + "_List"
+	case "Text":
+		return "capn.TextList"
+	case "Bool":
+		return "capn.BitList"
+	}
+	if _, isPrim := capnPrimitives[elem]; isPrim {
+		return "capn." + elem + "List"
+	}
+	return elem + "_List"
+}
+
+var capnPrimitives = map[string]bool{
+	"Int8": true, "Int16": true, "Int32": true, "Int64": true,
+	"UInt8": true, "UInt16": true, "UInt32": true, "UInt64": true,
+	"Float32": true, "Float64": true,
+}
+
+func (x *Extractor) WriteToViews(w io.Writer) (n int64, err error) {
+
+	var m int
+
+	x.GenerateViews()
+
+	// sort structs alphabetically to get a stable (testable) ordering.
+	sortedStructs := ByGoName(make([]*Struct, 0, len(x.srs)))
+	for _, strct := range x.srs {
+		sortedStructs = append(sortedStructs, strct)
+	}
+	sort.Sort(ByGoName(sortedStructs))
+
+	for _, s := range sortedStructs {
+		m, err = w.Write(x.ViewCode[s.goName])
+		n += int64(m)
+		if err != nil {
+			return
+		}
+	}
+
+	a := make([]AlphaHelper, 0, len(x.ListViewCode))
+	for k, v := range x.ListViewCode {
+		a = append(a, AlphaHelper{Name: k, Code: v})
+	}
+	sort.Sort(AlphaHelperSlice(a))
+
+	for _, help := range a {
+		m, err = w.Write(help.Code)
+		n += int64(m)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func ExtractViewString(src string) string {
+
+	x := NewExtractor()
+	defer x.Cleanup()
+	_, err := ExtractStructs("", "package main; "+src, x)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = x.WriteToViews(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return string(buf.Bytes())
+}
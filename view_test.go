@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestViewGettersAreLazy(t *testing.T) {
+
+	cv.Convey("Given a struct with a text slice, an int slice, a nested struct and a pointer to one", t, func() {
+		cv.Convey("then the generated CapnXView should hand back Go-typed getters, list views with Len() and At(i), and (view, ok) for the pointer", func() {
+
+			ex0 := `
+type Inner struct {
+  C int
+}
+type Outer struct {
+  Hello []string
+  World []int
+  In    Inner
+  Next  *Inner
+}
+`
+			out0 := ExtractViewString(ex0)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+type CapnOuterView struct {
+	src OuterCapn
+}
+
+func NewCapnOuterView(src OuterCapn) CapnOuterView {
+	return CapnOuterView{src: src}
+}
+`)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func (v CapnOuterView) Hello() []string {
+	return v.src.Hello().ToArray()
+}
+
+func (v CapnOuterView) World() CapnSliceIntView {
+	return CapnSliceIntView{src: capn.Int64List(v.src.World())}
+}
+
+func (v CapnOuterView) In() CapnInnerView {
+	return NewCapnInnerView(v.src.In())
+}
+
+// Next returns false if Next is nil.
+func (v CapnOuterView) Next() (CapnInnerView, bool) {
+	if capn.Object(v.src.Next()).Type() == capn.TypeNull {
+		return CapnInnerView{}, false
+	}
+	return NewCapnInnerView(v.src.Next()), true
+}
+`)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func (v CapnSliceIntView) Len() int {
+	return v.src.Len()
+}
+
+func (v CapnSliceIntView) At(i int) int {
+	return int(v.src.At(i))
+}
+`)
+		})
+	})
+}
+
+func TestViewOfSliceOfSlice(t *testing.T) {
+
+	cv.Convey("Given a struct with a [][]Big field", t, func() {
+		cv.Convey("then the outer list view should return inner list views from At(i), without converting them", func() {
+
+			ex0 := `
+type Big struct {
+  A int
+}
+type s1 struct {
+  Bigs [][]Big
+}
+`
+			out0 := ExtractViewString(ex0)
+
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func (v CapnSliceSliceBigView) At(i int) CapnSliceBigView {
+	return CapnSliceBigView{src: BigCapn_List(v.src.At(i))}
+}
+
+// ToSlice materializes the whole list.
+func (v CapnSliceSliceBigView) ToSlice() [][]Big {
+	s := make([][]Big, v.Len())
+	for i := range s {
+		s[i] = v.At(i).ToSlice()
+	}
+	return s
+}
+`)
+			cv.So(out0, ShouldContainModuloWhiteSpace, `
+func (v CapnSliceBigView) ToSlice() []Big {
+	s := make([]Big, v.Len())
+	for i := range s {
+		BigCapnToGo(v.src.At(i), &s[i])
+	}
+	return s
+}
+`)
+		})
+	})
+}